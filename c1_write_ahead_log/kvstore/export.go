@@ -0,0 +1,187 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatJSONLines ExportFormat = "jsonl"
+	ExportFormatCSV       ExportFormat = "csv"
+)
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(format) {
+	case "", ExportFormatJSONLines:
+		return ExportFormatJSONLines, nil
+	case ExportFormatCSV:
+		return ExportFormatCSV, nil
+	}
+	return "", ErrUnknownExportFormat
+}
+
+type exportRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type recordWriter interface {
+	write(record exportRecord) error
+	flush() error
+}
+
+type recordReader interface {
+	read() (exportRecord, error)
+}
+
+type jsonLinesRecordWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (writer *jsonLinesRecordWriter) write(record exportRecord) error {
+	return writer.encoder.Encode(record)
+}
+
+func (writer *jsonLinesRecordWriter) flush() error {
+	return writer.writer.Flush()
+}
+
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func (writer *csvRecordWriter) write(record exportRecord) error {
+	return writer.writer.Write([]string{record.Key, record.Value})
+}
+
+func (writer *csvRecordWriter) flush() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+func newRecordWriter(w io.Writer, format ExportFormat) (recordWriter, error) {
+	switch format {
+	case ExportFormatJSONLines:
+		buffered := bufio.NewWriter(w)
+		return &jsonLinesRecordWriter{writer: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write([]string{"key", "value"})
+		if err != nil {
+			return nil, err
+		}
+		return &csvRecordWriter{writer: writer}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+type jsonLinesRecordReader struct {
+	decoder *json.Decoder
+}
+
+func (reader *jsonLinesRecordReader) read() (exportRecord, error) {
+	var record exportRecord
+	err := reader.decoder.Decode(&record)
+	return record, err
+}
+
+type csvRecordReader struct {
+	reader    *csv.Reader
+	firstLine bool
+}
+
+func (reader *csvRecordReader) read() (exportRecord, error) {
+	row, err := reader.reader.Read()
+	if err != nil {
+		return exportRecord{}, err
+	}
+
+	//skip the header row if the file has one
+	if reader.firstLine {
+		reader.firstLine = false
+		if row[0] == "key" && row[1] == "value" {
+			return reader.read()
+		}
+	}
+
+	return exportRecord{Key: row[0], Value: row[1]}, nil
+}
+
+func newRecordReader(r io.Reader, format ExportFormat) (recordReader, error) {
+	switch format {
+	case ExportFormatJSONLines:
+		return &jsonLinesRecordReader{decoder: json.NewDecoder(r)}, nil
+	case ExportFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = 2
+		return &csvRecordReader{reader: reader, firstLine: true}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+func (store *KvStore) Export(w io.Writer, format ExportFormat) (int, error) {
+	writer, err := newRecordWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	store.mutex.RLock()
+	records := make([]exportRecord, 0, len(store.kv))
+	for key, value := range store.kv {
+		//deleted keys are kept in the map with an empty value
+		if value == "" {
+			continue
+		}
+		records = append(records, exportRecord{Key: key, Value: value})
+	}
+	store.mutex.RUnlock()
+
+	count := 0
+	for _, record := range records {
+		err = writer.write(record)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, writer.flush()
+}
+
+func (store *KvStore) Import(r io.Reader, format ExportFormat) (int, error) {
+	reader, err := newRecordReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		record, err := reader.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if record.Key == "" || record.Value == "" {
+			continue
+		}
+
+		err = store.Put(record.Key, record.Value)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
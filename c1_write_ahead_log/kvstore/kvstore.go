@@ -1,8 +1,12 @@
 package kvstore
 
+import "sync"
+
 type KvStore struct {
-	wal wal
-	kv  map[string]string
+	wal           wal
+	kv            map[string]string
+	mutex         sync.RWMutex
+	snapshotIndex *uint64
 }
 
 func NewKvStore() *KvStore {
@@ -11,11 +15,22 @@ func NewKvStore() *KvStore {
 		kv:  make(map[string]string),
 	}
 	store.applyLog()
+	store.startSnapshotTicker()
 	return &store
 }
 
 func (store *KvStore) applyLog() {
-	error := store.wal.ReadEntries()
+	snapshot, error := loadSnapshot()
+	if error != nil {
+		panic(error)
+	}
+
+	if snapshot != nil {
+		store.kv = snapshot.Kv
+		store.snapshotIndex = &snapshot.LastIndex
+	}
+
+	error = store.wal.ReadEntries()
 	if error != nil {
 		panic(error)
 	}
@@ -23,6 +38,11 @@ func (store *KvStore) applyLog() {
 	logEntries := store.wal.Entries
 
 	for _, entry := range logEntries {
+		//entries up to the snapshot's index are already in the map
+		if snapshot != nil && entry.Index <= snapshot.LastIndex {
+			continue
+		}
+
 		if entry.EntryType == WalEntryTypeSetCommand {
 			command := SetValueCommand{}
 			command.fromWalEntry(entry)
@@ -33,6 +53,10 @@ func (store *KvStore) applyLog() {
 			store.kv[command.Key] = ""
 		}
 	}
+
+	if snapshot != nil && store.wal.nextIndex <= snapshot.LastIndex {
+		store.wal.nextIndex = snapshot.LastIndex + 1
+	}
 }
 
 func (store *KvStore) Put(key string, value string) error {
@@ -45,12 +69,18 @@ func (store *KvStore) Put(key string, value string) error {
 		return err
 	}
 
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
 	store.wal.WriteEntry(&walEntry)
 	store.kv[key] = value
 	return nil
 }
 
 func (store *KvStore) Get(key string) string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
 	return store.kv[key]
 }
 
@@ -63,6 +93,9 @@ func (store *KvStore) Delete(key string) error {
 		return err
 	}
 
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
 	store.wal.WriteEntry(&walEntry)
 	store.kv[key] = ""
 	return nil
@@ -26,6 +26,7 @@ type wal struct {
 	Entries    []walEntry
 	file       *os.File
 	writeMutex sync.Mutex
+	nextIndex  uint64
 }
 
 const walFileName string = "kvwal.wal"
@@ -38,11 +39,7 @@ func (wal *wal) WriteEntry(entry *walEntry) {
 	wal.writeMutex.Lock()
 	defer wal.writeMutex.Unlock()
 
-	var index uint64 = 0
-	if len(wal.Entries) > 0 {
-		index = uint64(len(wal.Entries))
-	}
-	entry.Index = index
+	entry.Index = wal.nextIndex
 
 	if wal.file == nil {
 		file, err := os.OpenFile(walFileName, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
@@ -59,6 +56,7 @@ func (wal *wal) WriteEntry(entry *walEntry) {
 
 	if err == nil {
 		wal.Entries = append(wal.Entries, *entry)
+		wal.nextIndex++
 	}
 }
 
@@ -91,5 +89,56 @@ func (wal *wal) ReadEntries() error {
 	}
 
 	wal.Entries = entries
+	if len(entries) > 0 {
+		wal.nextIndex = entries[len(entries)-1].Index + 1
+	}
+	return nil
+}
+
+func (wal *wal) truncateThrough(index uint64) error {
+	wal.writeMutex.Lock()
+	defer wal.writeMutex.Unlock()
+
+	remaining := []walEntry{}
+	for _, entry := range wal.Entries {
+		if entry.Index > index {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	//rewrite the remaining entries next to the log and swap it in
+	tempFileName := walFileName + ".tmp"
+	file, err := os.Create(tempFileName)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, entry := range remaining {
+		bytes, _ := json.Marshal(entry)
+		writer.Write(append(bytes, '\n'))
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tempFileName)
+		return err
+	}
+
+	if wal.file != nil {
+		wal.file.Close()
+		wal.file = nil
+	}
+
+	err = os.Rename(tempFileName, walFileName)
+	if err != nil {
+		return err
+	}
+
+	wal.Entries = remaining
 	return nil
 }
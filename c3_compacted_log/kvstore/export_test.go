@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+var exportValues = map[string]string{
+	"plain":   "v",
+	"comma":   "a,b",
+	"quote":   `say "hi"`,
+	"newline": "first\nsecond",
+	"all":     "a,\"b\"\nc",
+}
+
+func inTempDir(t *testing.T) {
+	//the store keeps its files in the working directory
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	for _, format := range []ExportFormat{ExportFormatJSONLines, ExportFormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			inTempDir(t)
+			source := NewKvStore()
+			for key, value := range exportValues {
+				source.Put(key, value)
+			}
+			source.Put("deleted", "v")
+			source.Delete("deleted")
+
+			var exported bytes.Buffer
+			count, err := source.Export(&exported, format)
+			if err != nil || count != len(exportValues) {
+				t.Fatalf("exported %d keys, %v", count, err)
+			}
+
+			inTempDir(t)
+			target := NewKvStore()
+			count, err = target.Import(&exported, format)
+			if err != nil || count != len(exportValues) {
+				t.Fatalf("imported %d keys, %v", count, err)
+			}
+			for key, value := range exportValues {
+				if got := target.Get(key); got != value {
+					t.Fatalf("%s came back as %q, expected %q", key, got, value)
+				}
+			}
+			if target.Get("deleted") != "" {
+				t.Fatal("a deleted key came back")
+			}
+		})
+	}
+}
@@ -1,22 +1,37 @@
 package kvstore
 
+import "sync"
+
 type KvStore struct {
-	wal wal
-	kv  map[string]string
+	wal           *wal
+	kv            map[string]string
+	mutex         sync.RWMutex
+	snapshotIndex *uint64
 }
 
 func NewKvStore() *KvStore {
 	store := KvStore{
-		wal: *newWal(),
+		wal: newWal(),
 		kv:  make(map[string]string),
 	}
 	store.applyLog()
 	go store.wal.cleanSegments()
+	store.startSnapshotTicker()
 	return &store
 }
 
 func (store *KvStore) applyLog() {
-	error := store.wal.ReadEntries()
+	snapshot, error := loadSnapshot()
+	if error != nil {
+		panic(error)
+	}
+
+	if snapshot != nil {
+		store.kv = snapshot.Kv
+		store.snapshotIndex = &snapshot.LastIndex
+	}
+
+	error = store.wal.ReadEntries()
 	if error != nil {
 		panic(error)
 	}
@@ -24,6 +39,11 @@ func (store *KvStore) applyLog() {
 	logEntries := store.wal.Entries
 
 	for _, entry := range logEntries {
+		//entries up to the snapshot's index are already in the map
+		if snapshot != nil && entry.Index <= snapshot.LastIndex {
+			continue
+		}
+
 		if entry.EntryType == WalEntryTypeSetCommand {
 			command := SetValueCommand{}
 			command.fromWalEntry(entry)
@@ -46,12 +66,18 @@ func (store *KvStore) Put(key string, value string) error {
 		return err
 	}
 
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
 	store.wal.WriteEntry(&walEntry)
 	store.kv[key] = value
 	return nil
 }
 
 func (store *KvStore) Get(key string) string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
 	return store.kv[key]
 }
 
@@ -64,6 +90,9 @@ func (store *KvStore) Delete(key string) error {
 		return err
 	}
 
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
 	store.wal.WriteEntry(&walEntry)
 	store.kv[key] = ""
 	return nil
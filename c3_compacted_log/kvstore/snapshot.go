@@ -0,0 +1,122 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotInterval = 1 * time.Minute
+
+func snapshotPath() string {
+	return filepath.Join("dat", "meta", "kv_snapshot.dat")
+}
+
+type kvSnapshot struct {
+	LastIndex uint64            `json:"lastIndex"`
+	Kv        map[string]string `json:"kv"`
+}
+
+func loadSnapshot() (*kvSnapshot, error) {
+	bytes, err := os.ReadFile(snapshotPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshot kvSnapshot
+	err = json.Unmarshal(bytes, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot.Kv == nil {
+		snapshot.Kv = make(map[string]string)
+	}
+
+	return &snapshot, nil
+}
+
+func (snapshot *kvSnapshot) save() error {
+	bytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	//write next to the current snapshot so a crash never leaves a partial one
+	os.MkdirAll(filepath.Dir(snapshotPath()), 0755)
+	tempFileName := snapshotPath() + ".tmp"
+	file, err := os.Create(tempFileName)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tempFileName)
+		return err
+	}
+
+	return os.Rename(tempFileName, snapshotPath())
+}
+
+func (store *KvStore) takeSnapshot() error {
+	store.mutex.RLock()
+	if store.wal.nextIndex() == 0 {
+		store.mutex.RUnlock()
+		return nil
+	}
+
+	lastIndex := store.wal.nextIndex() - 1
+	if store.snapshotIndex != nil && *store.snapshotIndex == lastIndex {
+		store.mutex.RUnlock()
+		return nil
+	}
+
+	snapshot := kvSnapshot{
+		LastIndex: lastIndex,
+		Kv:        make(map[string]string, len(store.kv)),
+	}
+	for key, value := range store.kv {
+		if value != "" {
+			snapshot.Kv[key] = value
+		}
+	}
+	store.mutex.RUnlock()
+
+	err := snapshot.save()
+	if err != nil {
+		return err
+	}
+	store.snapshotIndex = &lastIndex
+
+	//everything up to the snapshot's index is now below the low-water mark of the log
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.wal.deleteSegmentsThrough(lastIndex)
+	return nil
+}
+
+func (store *KvStore) startSnapshotTicker() {
+	ticker := time.NewTicker(snapshotInterval)
+
+	go func() {
+		for range ticker.C {
+			//a failed snapshot leaves the log as it was, the next tick tries again
+			err := store.takeSnapshot()
+			if err != nil {
+				log.Printf("snapshot: %v", err)
+			}
+		}
+	}()
+}
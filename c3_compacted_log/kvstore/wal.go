@@ -172,6 +172,13 @@ func (wal *wal) loadMetadata() {
 
 func (wal *wal) readSegments() {
 	wal.loadMetadata()
+
+	if wal.metatada == nil {
+		wal.metatada = &walMetadata{
+			SortedSegmentsMetadata: []*walSegmentMetadata{},
+		}
+	}
+
 	var segments []*walSegment = []*walSegment{}
 
 	for _, meta := range wal.metatada.SortedSegmentsMetadata {
@@ -207,6 +214,22 @@ func (wal *wal) WriteEntry(entry *walEntry) error {
 	return err
 }
 
+func (wal *wal) WriteEntries(entries []*walEntry) error {
+	//metadata is saved once for the whole batch instead of after every entry
+	defer wal.saveMetadata()
+
+	for _, entry := range entries {
+		wal.maybeRoll()
+		err := wal.openSegment.writeEntry(entry, nil)
+		if err != nil {
+			return err
+		}
+		wal.Entries = append(wal.Entries, *entry)
+	}
+
+	return nil
+}
+
 func (wal *wal) ReadEntries() error {
 	wal.readSegments()
 
@@ -228,6 +251,43 @@ func (wal *wal) ReadEntries() error {
 	return nil
 }
 
+func (wal *wal) nextIndex() uint64 {
+	return wal.openSegment.meta.LastEntryIndex
+}
+
+func (wal *wal) deleteSegmentsThrough(index uint64) {
+	wal.segmentCleanupMutex.Lock()
+	defer wal.segmentCleanupMutex.Unlock()
+
+	remaining := make(map[string]bool)
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		remaining[meta.Id] = true
+	}
+
+	for _, meta := range append([]*walSegmentMetadata{}, wal.metatada.SortedSegmentsMetadata...) {
+		if meta.Closed && meta.isWhollyThrough(index) {
+			wal.deleteSegment(meta.Id)
+			remaining[meta.Id] = false
+		}
+	}
+
+	segments := []*walSegment{}
+	for _, segment := range wal.sortedSegments {
+		if remaining[segment.meta.Id] {
+			segments = append(segments, segment)
+		}
+	}
+	wal.sortedSegments = segments
+
+	entries := []walEntry{}
+	for _, entry := range wal.Entries {
+		if entry.Index > index {
+			entries = append(entries, entry)
+		}
+	}
+	wal.Entries = entries
+}
+
 func (wal *wal) startCleanupTicker() {
 	ticker := time.NewTicker(1 * time.Minute)
 
@@ -59,6 +59,14 @@ func (meta *walSegmentMetadata) isAtCapacity() bool {
 	return (meta.LastEntryIndex - meta.FirstEntryIndex) >= walSegmentSize
 }
 
+func (meta *walSegmentMetadata) isWhollyThrough(index uint64) bool {
+	//compacted segments record the index of their last entry, live segments the next free index
+	if meta.IsCompactedSegment {
+		return meta.LastEntryIndex <= index
+	}
+	return meta.LastEntryIndex <= index+1
+}
+
 func (walSegment *walSegment) writeEntry(entry *walEntry, index *uint64) error {
 	if walSegment.meta.Closed {
 		panic("cannot write to a closed segment")
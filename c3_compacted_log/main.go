@@ -75,7 +75,54 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 
 }
 
+func exportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	format, err := kvstore.ParseExportFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	if format == kvstore.ExportFormatCSV {
+		w.Header().Add("Content-Type", "text/csv")
+	} else {
+		w.Header().Add("Content-Type", "application/x-ndjson")
+	}
+
+	store.Export(w, format)
+}
+
+func importHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	format, err := kvstore.ParseExportFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	count, err := store.Import(req.Body, format)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"imported": count,
+	})
+}
+
 func main() {
 	http.HandleFunc("/", httpHandler)
+	http.HandleFunc("/export", exportHandler)
+	http.HandleFunc("/import", importHandler)
 	http.ListenAndServe(":8090", nil)
 }
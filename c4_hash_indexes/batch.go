@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"keyvault/kvstore"
+	"net/http"
+	"strconv"
+)
+
+type BatchRequest struct {
+	Ops []kvstore.BatchOp `json:"ops"`
+}
+
+type BatchResponse struct {
+	Indexes []uint64 `json:"indexes"`
+}
+
+func writeBatchResponse(w http.ResponseWriter, indexes []uint64) {
+	var last uint64 = 0
+	for _, index := range indexes {
+		if index > last {
+			last = index
+		}
+	}
+
+	//the highest index works as a min_index token for every write in the batch
+	w.Header().Set(indexHeader, strconv.FormatUint(last, 10))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{Indexes: indexes})
+}
+
+func readBatch(w http.ResponseWriter, req *http.Request) ([]byte, BatchRequest, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return nil, BatchRequest{}, false
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var request BatchRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		handleHttpError(w, err)
+		return nil, BatchRequest{}, false
+	}
+	return body, request, true
+}
+
+func handleBatchError(w http.ResponseWriter, e error) {
+	if errors.Is(e, kvstore.ErrEmptyBatch) || errors.Is(e, kvstore.ErrInvalidBatchOp) {
+		handleHttpError(w, e)
+		return
+	}
+	handleWriteError(w, e)
+}
+
+func batchHandler(w http.ResponseWriter, req *http.Request) {
+	setLeaderHint(w)
+	if req.Method == http.MethodPost && forwardToLeader(w, req) {
+		return
+	}
+
+	_, request, ok := readBatch(w, req)
+	if !ok {
+		return
+	}
+
+	if generation := store.Generation(); generation > 0 {
+		w.Header().Set(generationHeader, strconv.FormatUint(generation, 10))
+	}
+	if !checkGeneration(w, req) {
+		return
+	}
+
+	indexes, err := store.WriteBatch(req.Header.Get(clientIdHeader), request.Ops)
+	if err != nil {
+		handleBatchError(w, err)
+		return
+	}
+	writeBatchResponse(w, indexes)
+}
+
+func partitionedBatchHandler(w http.ResponseWriter, req *http.Request) {
+	body, request, ok := readBatch(w, req)
+	if !ok {
+		return
+	}
+	if len(request.Ops) == 0 {
+		handleBatchError(w, kvstore.ErrEmptyBatch)
+		return
+	}
+
+	w.Header().Set(partitionTableHeader, strconv.FormatUint(partitions.Table().Version, 10))
+
+	//operations are grouped by partition, each group is written to its own store
+	groups := make(map[int][]int)
+	order := []int{}
+	owner := ""
+	for i, op := range request.Ops {
+		route, err := partitions.Route(op.Key)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+		if owner != "" && route.Owner.Id != owner {
+			//the client routed with a table that is out of date, it fetches a new one and splits the batch again
+			http.Error(w, "batch spans several nodes, send each node the keys it owns", http.StatusMisdirectedRequest)
+			return
+		}
+		owner = route.Owner.Id
+
+		if route.Local {
+			if _, exists := groups[route.Partition]; !exists {
+				order = append(order, route.Partition)
+			}
+			groups[route.Partition] = append(groups[route.Partition], i)
+			continue
+		}
+
+		if req.Header.Get(forwardedHeader) != "" {
+			retryLater(w, "partition "+strconv.Itoa(route.Partition)+" is moving, retry shortly")
+			return
+		}
+		if i == len(request.Ops)-1 {
+			err = relay(w, req, route.Owner.Address, bytes.NewReader(body))
+			if err != nil {
+				retryLater(w, "partition owner "+route.Owner.Id+" is unreachable, retry shortly")
+				return
+			}
+			if version, err := strconv.ParseUint(w.Header().Get(partitionTableHeader), 10, 64); err == nil {
+				partitions.Observe(route.Owner, version)
+			}
+			return
+		}
+	}
+
+	clientId := req.Header.Get(clientIdHeader)
+	indexes := make([]uint64, len(request.Ops))
+	for _, partition := range order {
+		ops := []kvstore.BatchOp{}
+		keys := []string{}
+		for _, i := range groups[partition] {
+			ops = append(ops, request.Ops[i])
+			keys = append(keys, request.Ops[i].Key)
+		}
+
+		var written []uint64
+		err := partitions.Write(partition, keys, func(store *kvstore.KvStore) error {
+			var err error
+			written, err = store.WriteBatch(clientId, ops)
+			return err
+		})
+		if errors.Is(err, kvstore.ErrEmptyBatch) || errors.Is(err, kvstore.ErrInvalidBatchOp) {
+			handleBatchError(w, err)
+			return
+		}
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+
+		for j, i := range groups[partition] {
+			indexes[i] = written[j]
+		}
+	}
+	writeBatchResponse(w, indexes)
+}
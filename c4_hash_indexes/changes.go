@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"keyvault/kvstore"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const changesHeadInterval = 1 * time.Second
+
+func changeEventName(change kvstore.Change) string {
+	if change.EntryType == kvstore.WalEntryTypeDeleteCommand {
+		return "delete"
+	}
+	if change.EntryType == kvstore.WalEntryTypeNoOp {
+		return "noop"
+	}
+	if change.EntryType == kvstore.WalEntryTypeConfigChange {
+		return "config"
+	}
+	return "set"
+}
+
+func changesFromIndex(req *http.Request) (uint64, error) {
+	//a reconnecting EventSource resumes after the last event it saw
+	if lastEventId := req.Header.Get("Last-Event-ID"); lastEventId != "" {
+		index, err := strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return index + 1, nil
+	}
+
+	if from := req.URL.Query().Get("from"); from != "" {
+		return strconv.ParseUint(from, 10, 64)
+	}
+
+	return 0, nil
+}
+
+func changesHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	streamChanges(w, req, store)
+}
+
+func streamChanges(w http.ResponseWriter, req *http.Request, store *kvstore.KvStore) {
+	fromIndex, err := changesFromIndex(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := store.Subscribe(fromIndex)
+	defer sub.Close()
+
+	//the head doubles as the keep-alive, followers holding it know they have caught up
+	head := time.NewTicker(changesHeadInterval)
+	defer head.Stop()
+
+	for {
+		select {
+		case change, open := <-sub.Changes():
+			if !open {
+				//the client reconnects with Last-Event-ID and picks up from there
+				return
+			}
+
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Index, changeEventName(change), data)
+			flusher.Flush()
+		case <-head.C:
+			fmt.Fprintf(w, ": head %d\n\n", store.LastIndex())
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
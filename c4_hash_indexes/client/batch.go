@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keyvault/kvstore"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var errRerouted = errors.New("node no longer takes these keys")
+
+type pendingWrite struct {
+	ctx    context.Context
+	op     kvstore.BatchOp
+	result chan writeResult
+}
+
+type writeResult struct {
+	index uint64
+	err   error
+}
+
+type batcher struct {
+	client *Client
+	node   string
+	queue  chan *pendingWrite
+	done   chan struct{}
+}
+
+type batchRequest struct {
+	Ops []kvstore.BatchOp `json:"ops"`
+}
+
+type batchResponse struct {
+	Indexes []uint64 `json:"indexes"`
+}
+
+func newBatcher(client *Client, node string) *batcher {
+	batcher := &batcher{
+		client: client,
+		node:   node,
+		queue:  make(chan *pendingWrite, client.config.BatchSize*client.config.Pipelines),
+		done:   make(chan struct{}),
+	}
+
+	//each pipeline has its own session, so batches in flight side by side never race each other's sequences
+	host := node[strings.Index(node, "://")+3:]
+	for pipeline := 0; pipeline < client.config.Pipelines; pipeline++ {
+		go batcher.run(fmt.Sprintf("%s-%s-%d", client.config.ClientId, host, pipeline))
+	}
+	return batcher
+}
+
+func (batcher *batcher) close() {
+	close(batcher.done)
+}
+
+func (batcher *batcher) submit(ctx context.Context, op kvstore.BatchOp) (uint64, error) {
+	write := &pendingWrite{ctx: ctx, op: op, result: make(chan writeResult, 1)}
+	select {
+	case batcher.queue <- write:
+	case <-batcher.done:
+		return 0, ErrClosed
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	select {
+	case result := <-write.result:
+		return result.index, result.err
+	case <-batcher.done:
+		return 0, ErrClosed
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (batcher *batcher) next() []*pendingWrite {
+	var first *pendingWrite
+	select {
+	case first = <-batcher.queue:
+	case <-batcher.done:
+		return nil
+	}
+
+	//whatever else is already waiting shares the request, nothing waits for a batch to fill up
+	writes := []*pendingWrite{first}
+	for len(writes) < batcher.client.config.BatchSize {
+		select {
+		case write := <-batcher.queue:
+			writes = append(writes, write)
+		default:
+			return writes
+		}
+	}
+	return writes
+}
+
+func (batcher *batcher) run(sessionId string) {
+	var sequence uint64 = 0
+	for {
+		writes := batcher.next()
+		if writes == nil {
+			return
+		}
+
+		ops := []kvstore.BatchOp{}
+		for _, write := range writes {
+			sequence++
+			op := write.op
+			op.Sequence = sequence
+			ops = append(ops, op)
+		}
+
+		ctx, cancel := batcher.context(writes)
+		indexes, err := batcher.send(ctx, sessionId, ops)
+		cancel()
+		for i, write := range writes {
+			if err != nil {
+				write.result <- writeResult{err: err}
+			} else {
+				write.result <- writeResult{index: indexes[i]}
+			}
+		}
+	}
+}
+
+func (batcher *batcher) context(writes []*pendingWrite) (context.Context, context.CancelFunc) {
+	//a batch is given up once every caller in it has, or the client is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := atomic.Int32{}
+	waiting.Store(int32(len(writes)))
+	stops := []func() bool{}
+	for _, write := range writes {
+		stops = append(stops, context.AfterFunc(write.ctx, func() {
+			if waiting.Add(-1) == 0 {
+				cancel()
+			}
+		}))
+	}
+	go func() {
+		select {
+		case <-batcher.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+	}
+}
+
+func (batcher *batcher) send(ctx context.Context, sessionId string, ops []kvstore.BatchOp) ([]uint64, error) {
+	client := batcher.client
+	body, err := json.Marshal(batchRequest{Ops: ops})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set(clientIdHeader, sessionId)
+
+	//a retry sends the same sequences, so a batch that did land before is not applied twice,
+	//only a node that refused it for sure gets it again under fresh sequences
+	for attempt := 0; ; attempt++ {
+		response, err := client.send(ctx, http.MethodPost, batcher.node, "/batch", body, header)
+		if err != nil {
+			client.topology.forget(batcher.node)
+		} else {
+			stale := client.topology.observe(batcher.node, response.Header)
+			switch {
+			case response.StatusCode == http.StatusOK:
+				var result batchResponse
+				err = json.Unmarshal(response.Body, &result)
+				if err == nil && len(result.Indexes) != len(ops) {
+					err = fmt.Errorf("batch of %d writes was answered with %d indexes", len(ops), len(result.Indexes))
+				}
+				return result.Indexes, err
+			case response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusMisdirectedRequest:
+				return nil, errRerouted
+			case response.StatusCode == http.StatusServiceUnavailable && stale:
+				return nil, errRerouted
+			case response.StatusCode != http.StatusServiceUnavailable:
+				return nil, statusError(response)
+			}
+			err = statusError(response)
+		}
+
+		if attempt >= client.config.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if waitErr := client.backoff(ctx, attempt); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flakyNode struct {
+	dropped  int
+	requests []batchRequest
+	mutex    sync.Mutex
+}
+
+func (node *flakyNode) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/batch" {
+		http.NotFound(w, req)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var request batchRequest
+	json.Unmarshal(body, &request)
+
+	node.mutex.Lock()
+	node.requests = append(node.requests, request)
+	drop := len(node.requests) <= node.dropped
+	node.mutex.Unlock()
+
+	//the batch lands, but the answer never makes it back
+	if drop {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+		return
+	}
+
+	indexes := []uint64{}
+	for range request.Ops {
+		indexes = append(indexes, 1)
+	}
+	json.NewEncoder(w).Encode(batchResponse{Indexes: indexes})
+}
+
+func testClient(t *testing.T, url string) *Client {
+	config := DefaultConfig(url)
+	config.Backoff = time.Millisecond
+	config.MaxBackoff = 5 * time.Millisecond
+	config.Pipelines = 1
+	client, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestBatchRetriesKeepTheirSequence(t *testing.T) {
+	node := &flakyNode{dropped: 1 << 30}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.MaxRetries = 2
+	_, err := client.Put(context.Background(), "a", "1")
+	if err == nil {
+		t.Fatal("a write that never got an answer succeeded")
+	}
+
+	//a batch whose answer was lost may have been applied, so it is never sent again under a new sequence
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if len(node.requests) != 3 {
+		t.Fatalf("the batch was sent %d times", len(node.requests))
+	}
+	for _, request := range node.requests {
+		if request.Ops[0].Sequence != node.requests[0].Ops[0].Sequence {
+			t.Fatal("a retry went out under a new sequence")
+		}
+	}
+}
+
+func TestBatchRetriesStopWithTheCaller(t *testing.T) {
+	node := &flakyNode{dropped: 1 << 30}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.Backoff = 100 * time.Millisecond
+	client.config.MaxBackoff = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, err := client.Put(ctx, "a", "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("a cancelled write gave %v", err)
+	}
+
+	//the batch is given up along with its only caller instead of running through its retries
+	time.Sleep(300 * time.Millisecond)
+	node.mutex.Lock()
+	sent := len(node.requests)
+	node.mutex.Unlock()
+	time.Sleep(500 * time.Millisecond)
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if len(node.requests) != sent {
+		t.Fatal("the batch was still being retried after its caller gave up")
+	}
+}
@@ -0,0 +1,358 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"keyvault/kvstore"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	leaderHeader         = "X-Kv-Leader"
+	clientIdHeader       = "X-Kv-Client-Id"
+	partitionTableHeader = "X-Kv-Partition-Table"
+	hlcHeader            = "X-Kv-Hlc"
+)
+
+var ErrNoNodes = errors.New("client needs at least one node address")
+var ErrClosed = errors.New("client is closed")
+var ErrInvalidKey = errors.New("keys and values must not be empty")
+
+type Config struct {
+	Nodes               []string
+	ClientId            string
+	Timeout             time.Duration
+	MaxRetries          int
+	Backoff             time.Duration
+	MaxBackoff          time.Duration
+	MaxIdleConnsPerNode int
+	BatchSize           int
+	Pipelines           int
+}
+
+func DefaultConfig(nodes ...string) Config {
+	return Config{
+		Nodes:               nodes,
+		Timeout:             10 * time.Second,
+		MaxRetries:          8,
+		Backoff:             50 * time.Millisecond,
+		MaxBackoff:          2 * time.Second,
+		MaxIdleConnsPerNode: 16,
+		BatchSize:           100,
+		Pipelines:           4,
+	}
+}
+
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("node answered %d: %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+	config   Config
+	http     *http.Client
+	topology *topology
+	batchers map[string]*batcher
+	closed   bool
+	hlc      kvstore.Timestamp
+	mutex    sync.Mutex
+}
+
+type response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func New(config Config) (*Client, error) {
+	if config.ClientId == "" {
+		config.ClientId = uuid.NewString()
+	}
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+	if config.Pipelines < 1 {
+		config.Pipelines = 1
+	}
+
+	//one transport keeps a pool of connections to every node
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerNode
+
+	client := &Client{
+		config:   config,
+		http:     &http.Client{Timeout: config.Timeout, Transport: transport},
+		batchers: make(map[string]*batcher),
+	}
+	topology, err := newTopology(client, config.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	client.topology = topology
+	return client, nil
+}
+
+func (client *Client) Close() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.closed {
+		return
+	}
+	client.closed = true
+	for _, batcher := range client.batchers {
+		batcher.close()
+	}
+	client.http.CloseIdleConnections()
+}
+
+func (client *Client) send(ctx context.Context, method string, node string, path string, body []byte, header http.Header) (*response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, node+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	//every node asked is shown the latest time this client has seen, so causally later writes stay later
+	if hlc := client.Timestamp(); !hlc.IsZero() {
+		request.Header.Set(hlcHeader, hlc.String())
+	}
+
+	//redirects are followed by the http client, POST bodies included
+	httpResponse, err := client.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	data, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+	if hlc, err := kvstore.ParseTimestamp(httpResponse.Header.Get(hlcHeader)); err == nil {
+		client.observe(hlc)
+	}
+	return &response{StatusCode: httpResponse.StatusCode, Header: httpResponse.Header, Body: data}, nil
+}
+
+func (client *Client) Timestamp() kvstore.Timestamp {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.hlc
+}
+
+func (client *Client) observe(hlc kvstore.Timestamp) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if hlc.After(client.hlc) {
+		client.hlc = hlc
+	}
+}
+
+func (client *Client) backoff(ctx context.Context, attempt int) error {
+	delay := client.config.Backoff << attempt
+	if delay > client.config.MaxBackoff || delay <= 0 {
+		delay = client.config.MaxBackoff
+	}
+	//jitter keeps clients that failed together from retrying together
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func retryable(response *response) bool {
+	//leaders move, partitions freeze and nodes restart, all of which pass
+	return response.StatusCode == http.StatusServiceUnavailable ||
+		response.StatusCode == http.StatusForbidden ||
+		response.StatusCode == http.StatusMisdirectedRequest ||
+		response.StatusCode == http.StatusBadGateway
+}
+
+func (client *Client) call(ctx context.Context, method string, key string, path string, body []byte, header http.Header) (*response, error) {
+	for attempt := 0; ; attempt++ {
+		var node string
+		var err error
+		if key != "" {
+			node, err = client.topology.route(ctx, key)
+		} else {
+			node = client.topology.any()
+		}
+
+		var result *response
+		if err == nil {
+			result, err = client.send(ctx, method, node, path, body, header)
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+		if err != nil {
+			client.topology.forget(node)
+		} else {
+			client.topology.observe(node, result.Header)
+			if !retryable(result) {
+				return result, statusError(result)
+			}
+			err = statusError(result)
+		}
+
+		if attempt >= client.config.MaxRetries {
+			return nil, err
+		}
+		if waitErr := client.backoff(ctx, attempt); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+}
+
+func statusError(response *response) error {
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+	return &StatusError{StatusCode: response.StatusCode, Message: string(bytes.TrimSpace(response.Body))}
+}
+
+func (client *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	value, _, found, err := client.GetVersion(ctx, key)
+	return value, found, err
+}
+
+func (client *Client) GetVersion(ctx context.Context, key string) (string, kvstore.Timestamp, bool, error) {
+	if key == "" {
+		return "", kvstore.Timestamp{}, false, ErrInvalidKey
+	}
+
+	query := url.Values{"key": {key}}
+	response, err := client.call(ctx, http.MethodGet, key, "/?"+query.Encode(), nil, nil)
+	if err != nil {
+		return "", kvstore.Timestamp{}, false, err
+	}
+
+	var result struct {
+		Value   string          `json:"value"`
+		Version json.RawMessage `json:"version"`
+	}
+	err = json.Unmarshal(response.Body, &result)
+	if err != nil {
+		return "", kvstore.Timestamp{}, false, err
+	}
+
+	//leaderless nodes answer with the writing node next to the timestamp
+	var version struct {
+		kvstore.Timestamp
+		Leaderless kvstore.Timestamp `json:"timestamp"`
+	}
+	if len(result.Version) > 0 {
+		json.Unmarshal(result.Version, &version)
+	}
+	if version.Timestamp.IsZero() {
+		version.Timestamp = version.Leaderless
+	}
+
+	//values are never empty, so an empty one means the key is not there
+	return result.Value, version.Timestamp, result.Value != "", nil
+}
+
+func (client *Client) Put(ctx context.Context, key string, value string) (uint64, error) {
+	if key == "" || value == "" {
+		return 0, ErrInvalidKey
+	}
+	return client.write(ctx, kvstore.BatchOp{Key: key, Value: value})
+}
+
+func (client *Client) Delete(ctx context.Context, key string) (uint64, error) {
+	if key == "" {
+		return 0, ErrInvalidKey
+	}
+	return client.write(ctx, kvstore.BatchOp{Key: key, Delete: true})
+}
+
+func (client *Client) Scan(ctx context.Context, start string, end string, limit int) ([]kvstore.KeyValue, error) {
+	query := url.Values{"start": {start}, "end": {end}, "limit": {strconv.Itoa(limit)}}
+	return client.scan(ctx, query)
+}
+
+func (client *Client) ScanPrefix(ctx context.Context, prefix string, limit int) ([]kvstore.KeyValue, error) {
+	query := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	return client.scan(ctx, query)
+}
+
+func (client *Client) scan(ctx context.Context, query url.Values) ([]kvstore.KeyValue, error) {
+	//the node asked walks the ranges in order itself, so any node will do
+	response, err := client.call(ctx, http.MethodGet, "", "/scan?"+query.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []kvstore.KeyValue
+	err = json.Unmarshal(response.Body, &items)
+	return items, err
+}
+
+func (client *Client) write(ctx context.Context, op kvstore.BatchOp) (uint64, error) {
+	for attempt := 0; ; attempt++ {
+		node, err := client.topology.route(ctx, op.Key)
+		if err != nil {
+			return 0, err
+		}
+		batcher, err := client.batcher(node)
+		if err != nil {
+			return 0, err
+		}
+
+		index, err := batcher.submit(ctx, op)
+		if !errors.Is(err, errRerouted) {
+			return index, err
+		}
+
+		//the node no longer takes this key, so the write is routed again with a fresh sequence
+		if attempt >= client.config.MaxRetries {
+			return 0, err
+		}
+		if waitErr := client.backoff(ctx, attempt); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+}
+
+func (client *Client) batcher(node string) (*batcher, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.closed {
+		return nil, ErrClosed
+	}
+	batcher, exists := client.batchers[node]
+	if !exists {
+		batcher = newBatcher(client, node)
+		client.batchers[node] = batcher
+	}
+	return batcher, nil
+}
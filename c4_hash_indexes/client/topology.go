@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"keyvault/cluster"
+	"keyvault/partition"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type topology struct {
+	client     *Client
+	seeds      []string
+	leader     string
+	table      *partition.Table
+	loaded     bool
+	stale      bool
+	next       int
+	mutex      sync.Mutex
+	refreshing sync.Mutex
+}
+
+func newTopology(client *Client, nodes []string) (*topology, error) {
+	seeds := []string{}
+	for _, node := range nodes {
+		peer, err := cluster.NewPeer("seed", node)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, peer.Address)
+	}
+	if len(seeds) == 0 {
+		return nil, ErrNoNodes
+	}
+	return &topology{client: client, seeds: seeds}, nil
+}
+
+func (topology *topology) nodes() []string {
+	topology.mutex.Lock()
+	defer topology.mutex.Unlock()
+
+	//nodes the partition table knows about count as seeds too, the configured ones may be gone
+	nodes := append([]string{}, topology.seeds...)
+	if topology.table != nil {
+		for _, peer := range topology.table.Nodes {
+			if peer.Address != "" {
+				nodes = append(nodes, peer.Address)
+			}
+		}
+	}
+	return nodes
+}
+
+func (topology *topology) refresh(ctx context.Context) error {
+	topology.refreshing.Lock()
+	defer topology.refreshing.Unlock()
+
+	var lastErr error
+	for _, node := range topology.nodes() {
+		response, err := topology.client.send(ctx, http.MethodGet, node, partition.TablePath, nil, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		//a node without partitions answers the table path with its key handler, which refuses it
+		var table *partition.Table
+		if response.StatusCode == http.StatusOK {
+			table = &partition.Table{}
+			err = json.Unmarshal(response.Body, table)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		topology.mutex.Lock()
+		if table == nil || topology.table == nil || table.Version >= topology.table.Version {
+			topology.table = table
+		}
+		topology.loaded = true
+		topology.stale = false
+		topology.mutex.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+func (topology *topology) observe(node string, header http.Header) bool {
+	topology.mutex.Lock()
+	defer topology.mutex.Unlock()
+
+	if leader := header.Get(leaderHeader); leader != "" {
+		if peer, err := cluster.NewPeer("leader", leader); err == nil {
+			topology.leader = peer.Address
+		}
+	}
+
+	//an owner with a newer table has seen a move or a split this client has not
+	version, err := strconv.ParseUint(header.Get(partitionTableHeader), 10, 64)
+	if err == nil && topology.table != nil && version > topology.table.Version {
+		topology.stale = true
+	}
+	return topology.stale
+}
+
+func (topology *topology) forget(node string) {
+	topology.mutex.Lock()
+	defer topology.mutex.Unlock()
+
+	//an unreachable leader is looked for again through the other nodes
+	if topology.leader == node {
+		topology.leader = ""
+	}
+	topology.stale = topology.table != nil
+}
+
+func (topology *topology) route(ctx context.Context, key string) (string, error) {
+	topology.mutex.Lock()
+	needsRefresh := !topology.loaded || topology.stale
+	topology.mutex.Unlock()
+
+	if needsRefresh {
+		err := topology.refresh(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	topology.mutex.Lock()
+	defer topology.mutex.Unlock()
+
+	if topology.table != nil {
+		_, owner := topology.table.Owner(key)
+		for _, peer := range topology.table.Nodes {
+			if peer.Id == owner && peer.Address != "" {
+				return peer.Address, nil
+			}
+		}
+		//an owner the table has no address for is reached through any node, which relays
+		return topology.anyLocked(), nil
+	}
+	if topology.leader != "" {
+		return topology.leader, nil
+	}
+	return topology.anyLocked(), nil
+}
+
+func (topology *topology) any() string {
+	topology.mutex.Lock()
+	defer topology.mutex.Unlock()
+
+	if topology.table == nil && topology.leader != "" {
+		return topology.leader
+	}
+	return topology.anyLocked()
+}
+
+func (topology *topology) anyLocked() string {
+	topology.next = (topology.next + 1) % len(topology.seeds)
+	return topology.seeds[topology.next]
+}
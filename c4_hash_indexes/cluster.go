@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var heartbeat *cluster.Heartbeat
+var gossip *cluster.Gossip
+var liveness cluster.Liveness
+
+func handleCluster() {
+	//only the failure detector the node runs answers its peers
+	if gossip != nil {
+		http.HandleFunc(cluster.GossipPath, gossipMembersHandler)
+		http.HandleFunc(cluster.GossipPingPath, gossipHandler(gossip.HandlePing))
+		http.HandleFunc(cluster.GossipPingReqPath, gossipHandler(gossip.HandlePingRequest))
+		http.HandleFunc(cluster.GossipJoinPath, gossipHandler(gossip.HandleJoin))
+	} else {
+		http.HandleFunc(cluster.HeartbeatPath, heartbeatHandler)
+	}
+	http.HandleFunc("/cluster/health", clusterHealthHandler)
+}
+
+func heartbeatHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	err := heartbeat.HandleHeartbeat(body)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id": heartbeat.SelfId(),
+	})
+}
+
+func leaveOnShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		//a node told to stop says goodbye, so the others do not have to suspect it first
+		gossip.Leave()
+		os.Exit(0)
+	}()
+}
+
+func gossipHandler(handle func(body []byte) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		response, err := handle(body)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func gossipMembersHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gossip.Members())
+}
+
+func clusterHealthHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":    liveness.SelfId(),
+		"peers": liveness.Health(),
+	})
+}
+
+func handleMembershipError(w http.ResponseWriter, e error) {
+	if errors.Is(e, kvstore.ErrConfigChangeInProgress) || errors.Is(e, kvstore.ErrMemberExists) || errors.Is(e, kvstore.ErrLearnerBehind) {
+		http.Error(w, e.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(e, kvstore.ErrUnknownMember) || errors.Is(e, kvstore.ErrLastMember) || errors.Is(e, kvstore.ErrNotLearner) || errors.Is(e, kvstore.ErrNotVoter) {
+		handleHttpError(w, e)
+		return
+	}
+	handleWriteError(w, e)
+}
+
+type memberRequest struct {
+	Id      string `json:"id"`
+	Address string `json:"address"`
+	Learner bool   `json:"learner"`
+}
+
+func membersHandler(w http.ResponseWriter, req *http.Request) {
+	membership := store.Membership()
+	if membership == nil {
+		http.Error(w, kvstore.ErrRaftNotEnabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		body, _ := io.ReadAll(req.Body)
+
+		var request memberRequest
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		peer, err := cluster.NewPeer(request.Id, request.Address)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		//learners get the log but no vote until they are promoted
+		if request.Learner {
+			err = store.AddLearner(peer)
+		} else {
+			err = store.AddMember(peer)
+		}
+		if err != nil {
+			handleMembershipError(w, err)
+			return
+		}
+		membership = store.Membership()
+	} else if req.Method == http.MethodDelete {
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			handleHttpError(w, nil)
+			return
+		}
+
+		err := store.RemoveMember(id)
+		if err != nil {
+			handleMembershipError(w, err)
+			return
+		}
+		membership = store.Membership()
+	} else if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membership)
+}
+
+func promoteHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		handleHttpError(w, nil)
+		return
+	}
+
+	err := store.PromoteLearner(id)
+	if err != nil {
+		handleMembershipError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.Membership())
+}
+
+func transferLeaderHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		handleHttpError(w, nil)
+		return
+	}
+
+	err := store.TransferLeadership(id)
+	if err != nil {
+		handleMembershipError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.RaftStatus())
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+type Config struct {
+	Nodes []Peer `json:"nodes"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(bytes, &config)
+	if err != nil {
+		return config, err
+	}
+
+	for i, node := range config.Nodes {
+		config.Nodes[i], err = NewPeer(node.Id, node.Address)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+func (config Config) Node(id string) (Peer, bool) {
+	for _, node := range config.Nodes {
+		if node.Id == id {
+			return node, true
+		}
+	}
+	return Peer{}, false
+}
+
+func (peer Peer) ListenAddress() (string, error) {
+	parsed, err := url.Parse(peer.Address)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Port() == "" {
+		return "", fmt.Errorf("address of %s has no port", peer.Id)
+	}
+	return ":" + parsed.Port(), nil
+}
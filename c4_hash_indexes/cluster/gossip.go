@@ -0,0 +1,550 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	GossipPath        = "/cluster/gossip"
+	GossipPingPath    = "/cluster/gossip/ping"
+	GossipPingReqPath = "/cluster/gossip/ping-req"
+	GossipJoinPath    = "/cluster/gossip/join"
+)
+
+const (
+	gossipIndirectProbes = 3
+	gossipMaxPiggyback   = 16
+	gossipRetransmitMult = 3
+)
+
+var ErrNoSeedAnswered = errors.New("no seed answered the join")
+
+type GossipConfig struct {
+	Interval       time.Duration
+	SuspectTimeout time.Duration
+	//long enough for the news to have stopped spreading, or a stale rumor could bring the member back
+	ReapTimeout time.Duration
+}
+
+type Member struct {
+	Peer        Peer       `json:"peer"`
+	Status      PeerStatus `json:"status"`
+	Incarnation uint64     `json:"incarnation"`
+}
+
+type gossipMessage struct {
+	From    string   `json:"from"`
+	Updates []Member `json:"updates,omitempty"`
+}
+
+type pingRequest struct {
+	gossipMessage
+	Target string `json:"target"`
+}
+
+type pingRequestAnswer struct {
+	gossipMessage
+	Acked bool `json:"acked"`
+}
+
+type memberState struct {
+	Member
+	lastHeard   time.Time
+	suspectedAt time.Time
+	goneAt      time.Time
+}
+
+type queuedUpdate struct {
+	member    Member
+	transmits int
+}
+
+type Gossip struct {
+	self      Member
+	config    GossipConfig
+	members   map[string]*memberState
+	queue     map[string]*queuedUpdate
+	probeList []string
+	listeners []func(LivenessChange)
+	client    *http.Client
+	stop      chan struct{}
+	mutex     sync.Mutex
+}
+
+func NewGossip(self Peer, seeds []Peer, config GossipConfig) *Gossip {
+	gossip := &Gossip{
+		self:    Member{Peer: self, Status: PeerAlive},
+		config:  config,
+		members: make(map[string]*memberState),
+		queue:   make(map[string]*queuedUpdate),
+		client:  &http.Client{},
+		stop:    make(chan struct{}),
+	}
+
+	//configured peers start out alive, probing finds out soon enough when they are not
+	for _, peer := range seeds {
+		if peer.Id != self.Id {
+			gossip.members[peer.Id] = &memberState{Member: Member{Peer: peer, Status: PeerAlive}}
+		}
+	}
+	return gossip
+}
+
+func (gossip *Gossip) SelfId() string {
+	return gossip.self.Peer.Id
+}
+
+func (gossip *Gossip) Subscribe(listener func(LivenessChange)) {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	gossip.listeners = append(gossip.listeners, listener)
+}
+
+func (gossip *Gossip) Start() {
+	err := gossip.Join()
+	if err != nil {
+		log.Printf("gossip: %v, trying again", err)
+	}
+
+	ticker := time.NewTicker(gossip.config.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				//the seeds may not be up yet, and until one answers nobody knows this node exists
+				if err != nil {
+					err = gossip.Join()
+				}
+				gossip.probe()
+				gossip.expireSuspects()
+				gossip.reapGone()
+			case <-gossip.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (gossip *Gossip) Join() error {
+	gossip.mutex.Lock()
+	seeds := []Peer{}
+	for _, state := range gossip.members {
+		seeds = append(seeds, state.Peer)
+	}
+	self := gossip.self
+	gossip.mutex.Unlock()
+
+	//a seed answers with everything it knows, so one is enough
+	for _, seed := range seeds {
+		var answer gossipMessage
+		err := gossip.send(seed, GossipJoinPath, gossipMessage{From: self.Peer.Id, Updates: []Member{self}}, &answer, gossip.config.Interval)
+		if err != nil {
+			continue
+		}
+		gossip.apply(answer.Updates)
+		return nil
+	}
+	if len(seeds) == 0 {
+		return nil
+	}
+	return ErrNoSeedAnswered
+}
+
+func (gossip *Gossip) Leave() {
+	gossip.mutex.Lock()
+	gossip.self.Status = PeerLeft
+	gossip.self.Incarnation++
+	left := gossip.self
+	targets := gossip.reachableLocked()
+	gossip.mutex.Unlock()
+
+	//nobody will probe a node that is gone, so the news is told to everyone directly
+	var wait sync.WaitGroup
+	for _, peer := range targets {
+		wait.Add(1)
+		go func(peer Peer) {
+			defer wait.Done()
+			gossip.send(peer, GossipPingPath, gossipMessage{From: left.Peer.Id, Updates: []Member{left}}, nil, gossip.config.Interval)
+		}(peer)
+	}
+	wait.Wait()
+	close(gossip.stop)
+}
+
+func (gossip *Gossip) reachableLocked() []Peer {
+	peers := []Peer{}
+	for _, state := range gossip.members {
+		if state.Status.Reachable() {
+			peers = append(peers, state.Peer)
+		}
+	}
+	return peers
+}
+
+func (gossip *Gossip) nextTarget() (Peer, bool) {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	//members are probed in a shuffled round robin, so each is probed once every round
+	for len(gossip.probeList) > 0 {
+		id := gossip.probeList[0]
+		gossip.probeList = gossip.probeList[1:]
+		if state, exists := gossip.members[id]; exists && state.Status.Reachable() {
+			return state.Peer, true
+		}
+	}
+
+	for id, state := range gossip.members {
+		if state.Status.Reachable() {
+			gossip.probeList = append(gossip.probeList, id)
+		}
+	}
+	if len(gossip.probeList) == 0 {
+		return Peer{}, false
+	}
+	rand.Shuffle(len(gossip.probeList), func(i, j int) {
+		gossip.probeList[i], gossip.probeList[j] = gossip.probeList[j], gossip.probeList[i]
+	})
+	id := gossip.probeList[0]
+	gossip.probeList = gossip.probeList[1:]
+	return gossip.members[id].Peer, true
+}
+
+func (gossip *Gossip) probe() {
+	target, found := gossip.nextTarget()
+	if !found {
+		return
+	}
+
+	//a third of the period for the direct ping, the rest for asking others to try
+	timeout := gossip.config.Interval / 3
+	if gossip.ping(target, timeout) == nil {
+		return
+	}
+	if gossip.probeIndirectly(target, gossip.config.Interval-timeout) {
+		return
+	}
+
+	gossip.mutex.Lock()
+	state, exists := gossip.members[target.Id]
+	var changes []LivenessChange
+	if exists && state.Status == PeerAlive {
+		changes = gossip.applyLocked(Member{Peer: state.Peer, Status: PeerSuspected, Incarnation: state.Incarnation})
+	}
+	gossip.mutex.Unlock()
+	gossip.notify(changes)
+}
+
+func (gossip *Gossip) ping(target Peer, timeout time.Duration) error {
+	var answer gossipMessage
+	err := gossip.send(target, GossipPingPath, gossipMessage{From: gossip.SelfId(), Updates: gossip.piggyback()}, &answer, timeout)
+	if err != nil {
+		return err
+	}
+
+	gossip.mutex.Lock()
+	if state, exists := gossip.members[target.Id]; exists {
+		state.lastHeard = time.Now()
+	}
+	gossip.mutex.Unlock()
+	gossip.apply(answer.Updates)
+	return nil
+}
+
+func (gossip *Gossip) probeIndirectly(target Peer, timeout time.Duration) bool {
+	gossip.mutex.Lock()
+	helpers := []Peer{}
+	for id, state := range gossip.members {
+		if id != target.Id && state.Status == PeerAlive {
+			helpers = append(helpers, state.Peer)
+		}
+	}
+	gossip.mutex.Unlock()
+
+	//a few other members ping the target too, one of them getting through is enough
+	rand.Shuffle(len(helpers), func(i, j int) {
+		helpers[i], helpers[j] = helpers[j], helpers[i]
+	})
+	helpers = helpers[:min(gossipIndirectProbes, len(helpers))]
+	if len(helpers) == 0 {
+		return false
+	}
+
+	acks := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper Peer) {
+			var answer pingRequestAnswer
+			request := pingRequest{gossipMessage: gossipMessage{From: gossip.SelfId(), Updates: gossip.piggyback()}, Target: target.Id}
+			err := gossip.send(helper, GossipPingReqPath, request, &answer, timeout)
+			if err == nil {
+				gossip.apply(answer.Updates)
+			}
+			acks <- err == nil && answer.Acked
+		}(helper)
+	}
+
+	for range helpers {
+		if <-acks {
+			return true
+		}
+	}
+	return false
+}
+
+func (gossip *Gossip) expireSuspects() {
+	gossip.mutex.Lock()
+	changes := []LivenessChange{}
+	for _, state := range gossip.members {
+		if state.Status == PeerSuspected && time.Since(state.suspectedAt) >= gossip.config.SuspectTimeout {
+			changes = append(changes, gossip.applyLocked(Member{Peer: state.Peer, Status: PeerDead, Incarnation: state.Incarnation})...)
+		}
+	}
+	gossip.mutex.Unlock()
+	gossip.notify(changes)
+}
+
+func (gossip *Gossip) reapGone() {
+	if gossip.config.ReapTimeout <= 0 {
+		return
+	}
+
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	//members that died or left are forgotten after a while, a node that comes back joins again
+	for id, state := range gossip.members {
+		if !state.goneAt.IsZero() && time.Since(state.goneAt) >= gossip.config.ReapTimeout {
+			delete(gossip.members, id)
+			delete(gossip.queue, id)
+		}
+	}
+}
+
+func supersedes(update Member, current Member) bool {
+	//a higher incarnation always wins, within one the worse news does
+	if update.Incarnation != current.Incarnation {
+		return update.Incarnation > current.Incarnation
+	}
+	return update.Status > current.Status
+}
+
+func (gossip *Gossip) apply(updates []Member) {
+	gossip.mutex.Lock()
+	changes := []LivenessChange{}
+	for _, update := range updates {
+		changes = append(changes, gossip.applyLocked(update)...)
+	}
+	gossip.mutex.Unlock()
+	gossip.notify(changes)
+}
+
+func (gossip *Gossip) applyLocked(update Member) []LivenessChange {
+	if update.Peer.Id == gossip.self.Peer.Id {
+		//being suspected or declared dead while still running is refuted with a newer incarnation
+		if gossip.self.Status == PeerAlive && update.Status != PeerAlive && update.Incarnation >= gossip.self.Incarnation {
+			gossip.self.Incarnation = update.Incarnation + 1
+			gossip.enqueueLocked(gossip.self)
+		}
+		return nil
+	}
+
+	state, exists := gossip.members[update.Peer.Id]
+	if exists && !supersedes(update, state.Member) {
+		return nil
+	}
+
+	previous := PeerDead
+	if exists {
+		previous = state.Status
+	} else {
+		state = &memberState{}
+		gossip.members[update.Peer.Id] = state
+	}
+	if update.Peer.Address == "" {
+		update.Peer = state.Peer
+	}
+	state.Member = update
+	if update.Status == PeerSuspected && previous != PeerSuspected {
+		state.suspectedAt = time.Now()
+	}
+	if update.Status.Reachable() {
+		state.goneAt = time.Time{}
+	} else if state.goneAt.IsZero() {
+		state.goneAt = time.Now()
+	}
+	gossip.enqueueLocked(update)
+
+	if exists && previous == update.Status {
+		return nil
+	}
+	return []LivenessChange{{Peer: update.Peer, Previous: previous, Current: update.Status}}
+}
+
+func (gossip *Gossip) enqueueLocked(update Member) {
+	//newer news about a member replaces what was still being spread about it
+	gossip.queue[update.Peer.Id] = &queuedUpdate{member: update}
+}
+
+func (gossip *Gossip) piggyback() []Member {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	//every update is passed on a few times per doubling of the cluster, which reaches everyone with high probability
+	limit := gossipRetransmitMult * int(math.Ceil(math.Log2(float64(len(gossip.members)+2))))
+	queued := []*queuedUpdate{}
+	for _, update := range gossip.queue {
+		queued = append(queued, update)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].transmits < queued[j].transmits
+	})
+
+	updates := []Member{}
+	for _, update := range queued[:min(gossipMaxPiggyback, len(queued))] {
+		updates = append(updates, update.member)
+		update.transmits++
+		if update.transmits >= limit {
+			delete(gossip.queue, update.member.Peer.Id)
+		}
+	}
+	return updates
+}
+
+func (gossip *Gossip) notify(changes []LivenessChange) {
+	gossip.mutex.Lock()
+	listeners := append([]func(LivenessChange){}, gossip.listeners...)
+	gossip.mutex.Unlock()
+
+	for _, change := range changes {
+		for _, listener := range listeners {
+			listener(change)
+		}
+	}
+}
+
+func (gossip *Gossip) send(peer Peer, path string, request any, response any, timeout time.Duration) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, peer.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := gossip.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", peer.Id, httpResponse.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (gossip *Gossip) HandlePing(body []byte) (any, error) {
+	var message gossipMessage
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	gossip.apply(message.Updates)
+	return gossipMessage{From: gossip.SelfId(), Updates: gossip.piggyback()}, nil
+}
+
+func (gossip *Gossip) HandlePingRequest(body []byte) (any, error) {
+	var request pingRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+	gossip.apply(request.Updates)
+
+	gossip.mutex.Lock()
+	state, exists := gossip.members[request.Target]
+	gossip.mutex.Unlock()
+
+	//the asker waits a whole period at most, the ping through here has to fit inside it
+	acked := exists && gossip.ping(state.Peer, gossip.config.Interval/3) == nil
+	return pingRequestAnswer{gossipMessage: gossipMessage{From: gossip.SelfId(), Updates: gossip.piggyback()}, Acked: acked}, nil
+}
+
+func (gossip *Gossip) HandleJoin(body []byte) (any, error) {
+	var message gossipMessage
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return nil, err
+	}
+	gossip.apply(message.Updates)
+
+	//the joining node gets the whole view, including how it is seen itself
+	return gossipMessage{From: gossip.SelfId(), Updates: gossip.Members()}, nil
+}
+
+func (gossip *Gossip) Members() []Member {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	members := []Member{gossip.self}
+	for _, state := range gossip.members {
+		members = append(members, state.Member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Peer.Id < members[j].Peer.Id
+	})
+	return members
+}
+
+func (gossip *Gossip) PeerStatus(id string) PeerStatus {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	state, exists := gossip.members[id]
+	if !exists {
+		return PeerDead
+	}
+	return state.Status
+}
+
+func (gossip *Gossip) Health() []PeerHealth {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+
+	health := []PeerHealth{}
+	for _, state := range gossip.members {
+		peerHealth := PeerHealth{Peer: state.Peer, Status: state.Status}
+		if !state.lastHeard.IsZero() {
+			lastHeard := state.lastHeard
+			peerHealth.LastHeard = &lastHeard
+		}
+		health = append(health, peerHealth)
+	}
+
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Id < health[j].Id
+	})
+	return health
+}
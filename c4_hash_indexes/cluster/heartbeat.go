@@ -0,0 +1,320 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HeartbeatPath = "/cluster/heartbeat"
+
+type PeerStatus int
+
+const (
+	PeerAlive PeerStatus = iota
+	PeerSuspected
+	PeerDead
+	PeerLeft
+)
+
+func (status PeerStatus) String() string {
+	switch status {
+	case PeerAlive:
+		return "alive"
+	case PeerSuspected:
+		return "suspected"
+	case PeerDead:
+		return "dead"
+	case PeerLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+func (status PeerStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(status.String())
+}
+
+func (status *PeerStatus) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+
+	for candidate := PeerAlive; candidate <= PeerLeft; candidate++ {
+		if candidate.String() == name {
+			*status = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown peer status %q", name)
+}
+
+func (status PeerStatus) Reachable() bool {
+	return status == PeerAlive || status == PeerSuspected
+}
+
+type Peer struct {
+	Id      string `json:"id"`
+	Address string `json:"address"`
+}
+
+func ParsePeers(value string) ([]Peer, error) {
+	peers := []Peer{}
+	if value == "" {
+		return peers, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		id, address, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid peer %q, expected id=address", part)
+		}
+
+		peer, err := NewPeer(id, address)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+func NewPeer(id string, address string) (Peer, error) {
+	if id == "" || address == "" {
+		return Peer{}, fmt.Errorf("invalid peer %q, needs both an id and an address", id+"="+address)
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return Peer{Id: id, Address: strings.TrimSuffix(address, "/")}, nil
+}
+
+type HeartbeatConfig struct {
+	Interval       time.Duration
+	SuspectTimeout time.Duration
+	DeadTimeout    time.Duration
+}
+
+type LivenessChange struct {
+	Peer     Peer
+	Previous PeerStatus
+	Current  PeerStatus
+}
+
+type Liveness interface {
+	SelfId() string
+	PeerStatus(id string) PeerStatus
+	Health() []PeerHealth
+	Subscribe(listener func(LivenessChange))
+}
+
+type PeerHealth struct {
+	Peer
+	Status    PeerStatus `json:"status"`
+	LastHeard *time.Time `json:"lastHeard"`
+}
+
+type heartbeatMessage struct {
+	From string `json:"from"`
+}
+
+type peerState struct {
+	peer      Peer
+	lastHeard time.Time
+	heard     bool
+	status    PeerStatus
+}
+
+type Heartbeat struct {
+	selfId    string
+	config    HeartbeatConfig
+	peers     map[string]*peerState
+	listeners []func(LivenessChange)
+	startedAt time.Time
+	client    *http.Client
+	stop      chan struct{}
+	mutex     sync.Mutex
+}
+
+func NewHeartbeat(selfId string, peers []Peer, config HeartbeatConfig) *Heartbeat {
+	heartbeat := &Heartbeat{
+		selfId: selfId,
+		config: config,
+		peers:  make(map[string]*peerState),
+		client: &http.Client{Timeout: config.Interval},
+		stop:   make(chan struct{}),
+	}
+
+	for _, peer := range peers {
+		if peer.Id == selfId {
+			continue
+		}
+		//a peer is suspected until we hear from it for the first time
+		heartbeat.peers[peer.Id] = &peerState{peer: peer, status: PeerSuspected}
+	}
+
+	return heartbeat
+}
+
+func (heartbeat *Heartbeat) SelfId() string {
+	return heartbeat.selfId
+}
+
+func (heartbeat *Heartbeat) Subscribe(listener func(LivenessChange)) {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	heartbeat.listeners = append(heartbeat.listeners, listener)
+}
+
+func (heartbeat *Heartbeat) Start() {
+	heartbeat.mutex.Lock()
+	heartbeat.startedAt = time.Now()
+	heartbeat.mutex.Unlock()
+
+	ticker := time.NewTicker(heartbeat.config.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				heartbeat.sendHeartbeats()
+				heartbeat.checkPeers()
+			case <-heartbeat.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (heartbeat *Heartbeat) Stop() {
+	close(heartbeat.stop)
+}
+
+func (heartbeat *Heartbeat) Received(from string) {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	state, exists := heartbeat.peers[from]
+	if !exists {
+		return
+	}
+
+	state.lastHeard = time.Now()
+	state.heard = true
+}
+
+func (heartbeat *Heartbeat) PeerStatus(id string) PeerStatus {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	state, exists := heartbeat.peers[id]
+	if !exists {
+		return PeerDead
+	}
+	return state.status
+}
+
+func (heartbeat *Heartbeat) Health() []PeerHealth {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+
+	health := []PeerHealth{}
+	for _, state := range heartbeat.peers {
+		peerHealth := PeerHealth{Peer: state.peer, Status: state.status}
+		if state.heard {
+			lastHeard := state.lastHeard
+			peerHealth.LastHeard = &lastHeard
+		}
+		health = append(health, peerHealth)
+	}
+
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Id < health[j].Id
+	})
+	return health
+}
+
+func (heartbeat *Heartbeat) sendHeartbeats() {
+	heartbeat.mutex.Lock()
+	peers := []Peer{}
+	for _, state := range heartbeat.peers {
+		peers = append(peers, state.peer)
+	}
+	heartbeat.mutex.Unlock()
+
+	message, _ := json.Marshal(heartbeatMessage{From: heartbeat.selfId})
+
+	var wait sync.WaitGroup
+	for _, peer := range peers {
+		wait.Add(1)
+		go func(peer Peer) {
+			defer wait.Done()
+
+			response, err := heartbeat.client.Post(peer.Address+HeartbeatPath, "application/json", bytes.NewReader(message))
+			if err != nil {
+				return
+			}
+			response.Body.Close()
+
+			//an answered heartbeat is as good as one received
+			if response.StatusCode == http.StatusOK {
+				heartbeat.Received(peer.Id)
+			}
+		}(peer)
+	}
+	wait.Wait()
+}
+
+func (heartbeat *Heartbeat) checkPeers() {
+	now := time.Now()
+	changes := []LivenessChange{}
+
+	heartbeat.mutex.Lock()
+	for _, state := range heartbeat.peers {
+		lastHeard := state.lastHeard
+		if !state.heard {
+			lastHeard = heartbeat.startedAt
+		}
+
+		status := PeerAlive
+		silence := now.Sub(lastHeard)
+		if silence >= heartbeat.config.DeadTimeout {
+			status = PeerDead
+		} else if silence >= heartbeat.config.SuspectTimeout || !state.heard {
+			status = PeerSuspected
+		}
+
+		if status != state.status {
+			changes = append(changes, LivenessChange{Peer: state.peer, Previous: state.status, Current: status})
+			state.status = status
+		}
+	}
+	listeners := append([]func(LivenessChange){}, heartbeat.listeners...)
+	heartbeat.mutex.Unlock()
+
+	for _, change := range changes {
+		for _, listener := range listeners {
+			listener(change)
+		}
+	}
+}
+
+func (heartbeat *Heartbeat) HandleHeartbeat(body []byte) error {
+	var message heartbeatMessage
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return err
+	}
+
+	heartbeat.Received(message.From)
+	return nil
+}
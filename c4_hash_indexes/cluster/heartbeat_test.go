@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitForStatus(t *testing.T, heartbeat *Heartbeat, id string, status PeerStatus) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if heartbeat.PeerStatus(id) == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never saw %s as %s, it is %s", heartbeat.SelfId(), id, status, heartbeat.PeerStatus(id))
+}
+
+func TestHeartbeatDetectsAFailedPeer(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	servers := []*httptest.Server{}
+	heartbeats := make([]*Heartbeat, len(ids))
+	peers := []Peer{}
+	for i, id := range ids {
+		mux := http.NewServeMux()
+		mux.HandleFunc(HeartbeatPath, func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			if heartbeats[i].HandleHeartbeat(body) != nil {
+				http.Error(w, "invalid heartbeat", 400)
+			}
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		servers = append(servers, server)
+		peers = append(peers, Peer{Id: id, Address: server.URL})
+	}
+
+	config := HeartbeatConfig{Interval: 20 * time.Millisecond, SuspectTimeout: 100 * time.Millisecond, DeadTimeout: 300 * time.Millisecond}
+	for i, id := range ids {
+		heartbeats[i] = NewHeartbeat(id, peers, config)
+	}
+
+	//a peer is suspected until it is first heard from
+	if status := heartbeats[0].PeerStatus("b"); status != PeerSuspected {
+		t.Fatalf("a peer never heard from is %s", status)
+	}
+
+	var changes []LivenessChange
+	var mutex sync.Mutex
+	heartbeats[0].Subscribe(func(change LivenessChange) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if change.Peer.Id == "c" {
+			changes = append(changes, change)
+		}
+	})
+	for _, heartbeat := range heartbeats {
+		heartbeat.Start()
+	}
+	defer heartbeats[0].Stop()
+	defer heartbeats[1].Stop()
+
+	for _, heartbeat := range heartbeats {
+		for _, id := range ids {
+			if id != heartbeat.SelfId() {
+				waitForStatus(t, heartbeat, id, PeerAlive)
+			}
+		}
+	}
+
+	//a node that stops answering is suspected first and declared dead once the longer timeout passes
+	heartbeats[2].Stop()
+	servers[2].Close()
+	waitForStatus(t, heartbeats[0], "c", PeerDead)
+	waitForStatus(t, heartbeats[1], "c", PeerDead)
+	if status := heartbeats[0].PeerStatus("b"); status != PeerAlive {
+		t.Fatalf("the peer that kept running is %s", status)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	statuses := []PeerStatus{}
+	for _, change := range changes {
+		statuses = append(statuses, change.Current)
+	}
+	if !slices.Equal(statuses, []PeerStatus{PeerAlive, PeerSuspected, PeerDead}) {
+		t.Fatalf("subscribers were told %v", statuses)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"keyvault/kvstore"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kvctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  fsck    verify a data directory and optionally repair its metadata")
+	fmt.Fprintln(os.Stderr, "  export  stream every live key/value pair from a running node")
+	fmt.Fprintln(os.Stderr, "  import  bulk load an exported file into a running node")
+}
+
+func fsck(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dir := flags.String("dir", "dat", "data directory to check")
+	repair := flags.Bool("repair", false, "rebuild the metadata from the segment files on disk")
+	flags.Parse(args)
+
+	var issues []kvstore.FsckIssue
+	var err error
+	if *repair {
+		issues, err = kvstore.Repair(*dir)
+	} else {
+		issues, err = kvstore.Verify(*dir)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if len(issues) == 0 {
+		fmt.Printf("%s: ok\n", *dir)
+		return
+	}
+
+	if *repair {
+		fmt.Printf("%s: %d issues found, metadata rebuilt\n", *dir, len(issues))
+		return
+	}
+
+	fmt.Printf("%s: %d issues found, run with --repair to rebuild the metadata\n", *dir, len(issues))
+	os.Exit(1)
+}
+
+func formatFromPath(path string, format string) string {
+	if format == "" && strings.HasSuffix(path, ".csv") {
+		return string(kvstore.ExportFormatCSV)
+	}
+	return format
+}
+
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := flags.String("addr", "http://localhost:8090", "address of the node to export from")
+	format := flags.String("format", "", "jsonl or csv, defaults to jsonl unless -out ends in .csv")
+	out := flags.String("out", "", "file to write to, defaults to stdout")
+	flags.Parse(args)
+
+	query := url.Values{"format": {formatFromPath(*out, *format)}}
+	response, err := http.Get(*addr + "/export?" + query.Encode())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		fmt.Fprintf(os.Stderr, "export failed: %s: %s", response.Status, body)
+		os.Exit(1)
+	}
+
+	var writer io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	_, err = io.Copy(writer, response.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func importFile(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	addr := flags.String("addr", "http://localhost:8090", "address of the node to import into")
+	format := flags.String("format", "", "jsonl or csv, defaults to jsonl unless the file ends in .csv")
+	flags.Parse(args)
+
+	var reader io.Reader = os.Stdin
+	path := flags.Arg(0)
+	if path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	query := url.Values{"format": {formatFromPath(path, *format)}}
+	response, err := http.Post(*addr+"/import?"+query.Encode(), "application/octet-stream", reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "import failed: %s: %s", response.Status, body)
+		os.Exit(1)
+	}
+
+	fmt.Print(string(body))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "fsck":
+		fsck(os.Args[2:])
+	case "export":
+		export(os.Args[2:])
+	case "import":
+		importFile(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+const leaderHeader = "X-Kv-Leader"
+const forwardedHeader = "X-Kv-Forwarded"
+const leaderRetryAfter = "1"
+
+var forwardClient = &http.Client{Timeout: 30 * time.Second}
+
+func setLeaderHint(w http.ResponseWriter) {
+	if leader := store.LeaderUrl(); leader != "" {
+		w.Header().Set(leaderHeader, leader)
+	}
+}
+
+func retryLater(w http.ResponseWriter, message string) {
+	w.Header().Set("Retry-After", leaderRetryAfter)
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
+
+func forwardToLeader(w http.ResponseWriter, req *http.Request) bool {
+	if store.IsLeader() {
+		return false
+	}
+
+	//a forwarded write is never forwarded again, the leader it was meant for has just stepped down
+	leader := store.LeaderUrl()
+	if leader == "" || req.Header.Get(forwardedHeader) != "" {
+		retryLater(w, "no leader is known right now, retry shortly")
+		return true
+	}
+
+	err := relay(w, req, leader, req.Body)
+	if err != nil {
+		retryLater(w, "leader "+leader+" is unreachable, retry shortly")
+	}
+	return true
+}
+
+func relay(w http.ResponseWriter, req *http.Request, target string, body io.Reader) error {
+	forward, err := http.NewRequestWithContext(req.Context(), req.Method, target+req.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+	forward.Header.Set(forwardedHeader, "1")
+	for _, name := range []string{"Content-Type", generationHeader, clientIdHeader, sequenceHeader, hlcHeader} {
+		if value := req.Header.Get(name); value != "" {
+			forward.Header.Set(name, value)
+		}
+	}
+
+	response, err := forwardClient.Do(forward)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	observeClock(response.Header)
+
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+	return nil
+}
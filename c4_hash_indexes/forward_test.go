@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"keyvault/kvstore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFollowerForwardsWrites(t *testing.T) {
+	var forwarded *http.Request
+	var forwardedBody string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		forwarded, forwardedBody = req, string(body)
+		w.Header().Set(indexHeader, "7")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "written by the leader")
+	}))
+	defer leader.Close()
+
+	previous := store
+	defer func() {
+		store = previous
+	}()
+	store = kvstore.NewKvStore(t.TempDir())
+	defer store.Close()
+	store.FollowLeader(leader.URL)
+
+	//the follower relays the leader's answer and tells the client where the leader is
+	w := httptest.NewRecorder()
+	httpHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"a","value":"1"}`)))
+	if w.Code != http.StatusCreated || w.Body.String() != "written by the leader" || w.Header().Get(indexHeader) != "7" {
+		t.Fatalf("the follower answered %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get(leaderHeader) != leader.URL {
+		t.Fatalf("the leader hint was %q", w.Header().Get(leaderHeader))
+	}
+	if forwarded == nil || forwarded.Method != http.MethodPost || forwardedBody != `{"key":"a","value":"1"}` || forwarded.Header.Get(forwardedHeader) == "" {
+		t.Fatalf("the leader was sent %v %q", forwarded, forwardedBody)
+	}
+
+	w = httptest.NewRecorder()
+	httpHandler(w, httptest.NewRequest(http.MethodDelete, "/?key=a", nil))
+	if w.Code != http.StatusCreated || forwarded.Method != http.MethodDelete || forwarded.URL.Query().Get("key") != "a" {
+		t.Fatalf("a delete was answered %d and sent as %v", w.Code, forwarded)
+	}
+	if store.Get("a") != nil {
+		t.Fatal("the follower wrote a forwarded key itself")
+	}
+
+	//a write that was already forwarded once is not passed on again
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"a","value":"1"}`))
+	request.Header.Set(forwardedHeader, "1")
+	w = httptest.NewRecorder()
+	httpHandler(w, request)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("a write forwarded twice was answered %d", w.Code)
+	}
+
+	//a leader that cannot be reached gets the client to retry
+	leader.Close()
+	w = httptest.NewRecorder()
+	httpHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"a","value":"1"}`)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("a write with the leader down was answered %d", w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"keyvault/cluster"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	//the test binary runs the node itself when started as one of the cluster's processes
+	if os.Getenv("KEYVAULT_TEST_NODE") != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func startNode(t *testing.T, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "KEYVAULT_TEST_NODE=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func gossipMembers(address string) map[string]cluster.PeerStatus {
+	response, err := http.Get("http://" + address + cluster.GossipPath)
+	if err != nil {
+		return nil
+	}
+	defer response.Body.Close()
+
+	var members []cluster.Member
+	if json.NewDecoder(response.Body).Decode(&members) != nil {
+		return nil
+	}
+	statuses := make(map[string]cluster.PeerStatus)
+	for _, member := range members {
+		statuses[member.Peer.Id] = member.Status
+	}
+	return statuses
+}
+
+func waitForMembers(t *testing.T, address string, timeout time.Duration, done func(map[string]cluster.PeerStatus) bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if members := gossipMembers(address); members != nil && done(members) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s never saw the expected members, last saw %v", address, gossipMembers(address))
+}
+
+func TestGossipAcrossProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts several nodes")
+	}
+
+	ids := []string{"n1", "n2", "n3"}
+	addresses := []string{}
+	for range ids {
+		addresses = append(addresses, freeAddress(t))
+	}
+
+	//every node only knows the first one, the rest is learned through gossip
+	nodes := []*exec.Cmd{}
+	for i, id := range ids {
+		args := []string{
+			"-gossip", "-id", id, "-addr", addresses[i], "-advertise", addresses[i], "-dir", t.TempDir(),
+			"-heartbeat-interval", "100ms", "-dead-timeout", "500ms", "-reap-timeout", "2s",
+		}
+		if i > 0 {
+			args = append(args, "-peers", fmt.Sprintf("%s=%s", ids[0], addresses[0]))
+		}
+		nodes = append(nodes, startNode(t, args...))
+	}
+
+	for _, address := range addresses {
+		waitForMembers(t, address, 10*time.Second, func(members map[string]cluster.PeerStatus) bool {
+			for _, id := range ids {
+				if status, found := members[id]; !found || status != cluster.PeerAlive {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	//a node that crashes is declared dead and forgotten once the reap timeout passes
+	nodes[2].Process.Kill()
+	for _, address := range addresses[:2] {
+		waitForMembers(t, address, 5*time.Second, func(members map[string]cluster.PeerStatus) bool {
+			return members["n3"] == cluster.PeerDead
+		})
+	}
+	for _, address := range addresses[:2] {
+		waitForMembers(t, address, 5*time.Second, func(members map[string]cluster.PeerStatus) bool {
+			_, found := members["n3"]
+			return !found && members["n2"] == cluster.PeerAlive && members["n1"] == cluster.PeerAlive
+		})
+	}
+
+	//a node told to stop leaves instead of being suspected, and is forgotten the same way
+	nodes[1].Process.Signal(os.Interrupt)
+	waitForMembers(t, addresses[0], 5*time.Second, func(members map[string]cluster.PeerStatus) bool {
+		return members["n2"] == cluster.PeerLeft
+	})
+	waitForMembers(t, addresses[0], 5*time.Second, func(members map[string]cluster.PeerStatus) bool {
+		_, found := members["n2"]
+		return !found && len(members) == 1 && members["n1"] == cluster.PeerAlive
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"keyvault/kvstore"
+	"net/http"
+)
+
+const hlcHeader = "X-Kv-Hlc"
+
+type clockWriter struct {
+	http.ResponseWriter
+	stamped bool
+}
+
+func (w *clockWriter) stamp() {
+	//read when the answer goes out, so it is past anything the request wrote
+	if !w.stamped {
+		w.stamped = true
+		w.Header().Set(hlcHeader, kvstore.NodeClock().Now().String())
+	}
+}
+
+func (w *clockWriter) WriteHeader(statusCode int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *clockWriter) Write(data []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *clockWriter) Flush() {
+	w.stamp()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func observeClock(header http.Header) error {
+	value := header.Get(hlcHeader)
+	if value == "" {
+		return nil
+	}
+
+	timestamp, err := kvstore.ParseTimestamp(value)
+	if err != nil {
+		return err
+	}
+	_, err = kvstore.NodeClock().Update(timestamp)
+	return err
+}
+
+func withClock(handler http.Handler) http.Handler {
+	//clients and nodes pass the latest time they saw, so whatever they do next is ordered after it
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := observeClock(req.Header)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+		handler.ServeHTTP(&clockWriter{ResponseWriter: w}, req)
+	})
+}
@@ -0,0 +1,77 @@
+package kvstore
+
+import "errors"
+
+var ErrEmptyBatch = errors.New("a batch needs at least one operation")
+var ErrInvalidBatchOp = errors.New("batch operations need a key, and a value unless they delete")
+
+type BatchOp struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Delete   bool   `json:"delete,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+func (op BatchOp) toWalEntry(clientId string) (walEntry, error) {
+	var entry walEntry
+	var err error
+	if op.Delete {
+		command := DeleteValueCommand{Key: op.Key}
+		entry, err = command.toWalEntry()
+	} else {
+		command := SetValueCommand{Key: op.Key, Value: op.Value}
+		entry, err = command.toWalEntry()
+	}
+	entry.ClientId = clientId
+	entry.Sequence = op.Sequence
+	return entry, err
+}
+
+func (store *KvStore) WriteBatch(clientId string, ops []BatchOp) ([]uint64, error) {
+	if store.IsFollower() {
+		return nil, ErrReadOnlyFollower
+	}
+	if len(ops) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	entries := []*walEntry{}
+	var sequence uint64 = 0
+	for _, op := range ops {
+		if op.Key == "" || (!op.Delete && op.Value == "") {
+			return nil, ErrInvalidBatchOp
+		}
+		//every operation takes its own sequence so a retried batch is turned away as a whole
+		if clientId != "" && op.Sequence <= sequence {
+			return nil, ErrMissingSequence
+		}
+		sequence = op.Sequence
+
+		entry, err := op.toWalEntry(clientId)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	if clientId != "" {
+		indexes, duplicate, err := store.sessions.lookupBatch(clientId, sequence, len(ops))
+		if err != nil {
+			return nil, err
+		}
+		if duplicate {
+			return indexes, nil
+		}
+	}
+
+	err := store.writeEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []uint64{}
+	for _, entry := range entries {
+		indexes = append(indexes, store.appliedIndexFor(*entry))
+	}
+	return indexes, nil
+}
@@ -0,0 +1,35 @@
+package kvstore
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRetriedBatchAnswersWithTheSameIndexes(t *testing.T) {
+	store := NewKvStore(t.TempDir())
+	defer store.Close()
+
+	store.Put("before", "v")
+	ops := []BatchOp{
+		{Key: "a", Value: "1", Sequence: 1},
+		{Key: "b", Delete: true, Sequence: 2},
+		{Key: "c", Value: "3", Sequence: 3},
+	}
+	first, err := store.WriteBatch("client", ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("after", "v")
+
+	//the retry is turned away, but hands back the write tokens the first attempt did
+	retried, err := store.WriteBatch("client", ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(first, retried) {
+		t.Fatalf("the batch was written at %v, the retry answered %v", first, retried)
+	}
+	if store.LastIndex() != first[len(first)-1]+1 {
+		t.Fatal("the retry was written again")
+	}
+}
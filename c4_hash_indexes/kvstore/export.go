@@ -0,0 +1,233 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatJSONLines ExportFormat = "jsonl"
+	ExportFormatCSV       ExportFormat = "csv"
+)
+
+const importBatchSize = 1000
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(format) {
+	case "", ExportFormatJSONLines:
+		return ExportFormatJSONLines, nil
+	case ExportFormatCSV:
+		return ExportFormatCSV, nil
+	}
+	return "", ErrUnknownExportFormat
+}
+
+type exportRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type recordWriter interface {
+	write(record exportRecord) error
+	flush() error
+}
+
+type recordReader interface {
+	read() (exportRecord, error)
+}
+
+type jsonLinesRecordWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (writer *jsonLinesRecordWriter) write(record exportRecord) error {
+	return writer.encoder.Encode(record)
+}
+
+func (writer *jsonLinesRecordWriter) flush() error {
+	return writer.writer.Flush()
+}
+
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func (writer *csvRecordWriter) write(record exportRecord) error {
+	return writer.writer.Write([]string{record.Key, record.Value})
+}
+
+func (writer *csvRecordWriter) flush() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+func newRecordWriter(w io.Writer, format ExportFormat) (recordWriter, error) {
+	switch format {
+	case ExportFormatJSONLines:
+		buffered := bufio.NewWriter(w)
+		return &jsonLinesRecordWriter{writer: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write([]string{"key", "value"})
+		if err != nil {
+			return nil, err
+		}
+		return &csvRecordWriter{writer: writer}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+type jsonLinesRecordReader struct {
+	decoder *json.Decoder
+}
+
+func (reader *jsonLinesRecordReader) read() (exportRecord, error) {
+	var record exportRecord
+	err := reader.decoder.Decode(&record)
+	return record, err
+}
+
+type csvRecordReader struct {
+	reader    *csv.Reader
+	firstLine bool
+}
+
+func (reader *csvRecordReader) read() (exportRecord, error) {
+	row, err := reader.reader.Read()
+	if err != nil {
+		return exportRecord{}, err
+	}
+
+	//skip the header row if the file has one
+	if reader.firstLine {
+		reader.firstLine = false
+		if row[0] == "key" && row[1] == "value" {
+			return reader.read()
+		}
+	}
+
+	return exportRecord{Key: row[0], Value: row[1]}, nil
+}
+
+func newRecordReader(r io.Reader, format ExportFormat) (recordReader, error) {
+	switch format {
+	case ExportFormatJSONLines:
+		return &jsonLinesRecordReader{decoder: json.NewDecoder(r)}, nil
+	case ExportFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = 2
+		return &csvRecordReader{reader: reader, firstLine: true}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+func (store *KvStore) Export(w io.Writer, format ExportFormat) (int, error) {
+	writer, err := newRecordWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	locations := store.wal.latestEntryLocations(func(key string) bool {
+		return true
+	})
+
+	count := 0
+	for _, location := range locations {
+		entry := location.segment.ReadEntryAtOffset(location.offset)
+		if entry == nil {
+			continue
+		}
+
+		key, value := entry.keyValue()
+		if key == nil || value == nil {
+			continue
+		}
+
+		err = writer.write(exportRecord{Key: *key, Value: *value})
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, writer.flush()
+}
+
+func (store *KvStore) Import(r io.Reader, format ExportFormat) (int, error) {
+	if store.IsFollower() {
+		return 0, ErrReadOnlyFollower
+	}
+
+	reader, err := newRecordReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	batch := []*walEntry{}
+	for {
+		record, err := reader.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if record.Key == "" || record.Value == "" {
+			continue
+		}
+
+		command := SetValueCommand{
+			Key:   record.Key,
+			Value: record.Value,
+		}
+		entry, err := command.toWalEntry()
+		if err != nil {
+			return count, err
+		}
+		batch = append(batch, &entry)
+
+		if len(batch) >= importBatchSize {
+			err = store.writeEntries(batch)
+			if err != nil {
+				return count, err
+			}
+			count += len(batch)
+			batch = []*walEntry{}
+		}
+	}
+
+	err = store.writeEntries(batch)
+	if err != nil {
+		return count, err
+	}
+
+	return count + len(batch), nil
+}
+
+func (store *KvStore) writeEntries(entries []*walEntry) error {
+	if store.raft != nil {
+		return store.raft.propose(entries)
+	}
+	err := store.wal.WriteEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	//with raft the watches fire as entries are applied, a plain log notifies here like writeEntry does
+	for _, entry := range entries {
+		if store.appliedIndexFor(*entry) == entry.Index {
+			store.watches.notify(entry.toChange())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package kvstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+var exportValues = map[string]string{
+	"plain":   "v",
+	"comma":   "a,b",
+	"quote":   `say "hi"`,
+	"newline": "first\nsecond",
+	"all":     "a,\"b\"\nc",
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	for _, format := range []ExportFormat{ExportFormatJSONLines, ExportFormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			source := NewKvStore(t.TempDir())
+			defer source.Close()
+			for key, value := range exportValues {
+				source.Put(key, value)
+			}
+			source.Put("deleted", "v")
+			source.Delete("deleted")
+
+			var exported bytes.Buffer
+			count, err := source.Export(&exported, format)
+			if err != nil || count != len(exportValues) {
+				t.Fatalf("exported %d keys, %v", count, err)
+			}
+
+			target := NewKvStore(t.TempDir())
+			defer target.Close()
+			count, err = target.Import(&exported, format)
+			if err != nil || count != len(exportValues) {
+				t.Fatalf("imported %d keys, %v", count, err)
+			}
+			for key, value := range exportValues {
+				if got := target.Get(key); got == nil || *got != value {
+					t.Fatalf("%s came back as %v, expected %q", key, got, value)
+				}
+			}
+			if target.Get("deleted") != nil {
+				t.Fatal("a deleted key came back")
+			}
+		})
+	}
+}
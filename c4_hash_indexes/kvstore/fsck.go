@@ -0,0 +1,348 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type FsckIssue struct {
+	SegmentId string `json:"segmentId,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Problem   string `json:"problem"`
+}
+
+func (issue FsckIssue) String() string {
+	if issue.Path != "" {
+		return fmt.Sprintf("%s: %s", issue.Path, issue.Problem)
+	}
+	if issue.SegmentId != "" {
+		return fmt.Sprintf("segment %s: %s", issue.SegmentId, issue.Problem)
+	}
+	return issue.Problem
+}
+
+type segmentFile struct {
+	path         string
+	segmentIndex uint64
+	id           string
+}
+
+type segmentFileScan struct {
+	entries      []walEntry
+	validLength  int64
+	corruptAt    *int64
+	corruptCause error
+}
+
+func Verify(dir string) ([]FsckIssue, error) {
+	issues := []FsckIssue{}
+
+	metadata, err := readMetadataFile(filepath.Join(dir, "meta", "wal_metadata.dat"))
+	if err != nil {
+		issues = append(issues, FsckIssue{Problem: fmt.Sprintf("unreadable metadata: %v", err)})
+	}
+
+	files, err := listSegmentFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		if len(files) > 0 {
+			issues = append(issues, FsckIssue{Problem: fmt.Sprintf("metadata missing but %d segment files exist", len(files))})
+		}
+		metadata = &walMetadata{SortedSegmentsMetadata: []*walSegmentMetadata{}}
+	}
+
+	referenced := make(map[string]bool)
+	for i, meta := range metadata.SortedSegmentsMetadata {
+		path := meta.segmentLogFilePath(dir)
+		issue := func(problem string, args ...any) {
+			issues = append(issues, FsckIssue{SegmentId: meta.Id, Path: path, Problem: fmt.Sprintf(problem, args...)})
+		}
+
+		if referenced[path] {
+			issue("listed more than once in metadata")
+			continue
+		}
+		referenced[path] = true
+
+		if meta.IsCompactedSegment && !meta.CompactionCompleted {
+			issue("left behind by an interrupted compaction")
+		}
+		if meta.holdsNoEntries() {
+			continue
+		}
+
+		isLast := i == len(metadata.SortedSegmentsMetadata)-1
+		if !isLast && !meta.Closed {
+			issue("not closed but is not the last segment")
+		}
+
+		if !meta.IsCompactedSegment && meta.LastEntryIndex < meta.FirstEntryIndex {
+			issue("lastEntryIndex %d is below firstEntryIndex %d", meta.LastEntryIndex, meta.FirstEntryIndex)
+		}
+
+		scan, err := scanSegmentFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				//an open segment has no file until its first write
+				if meta.IsCompactedSegment || meta.LastEntryIndex > meta.FirstEntryIndex {
+					issue("segment file missing")
+				}
+				continue
+			}
+			issue("unreadable segment file: %v", err)
+			continue
+		}
+
+		if scan.corruptAt != nil {
+			issue("corrupt entry at offset %d: %v", *scan.corruptAt, scan.corruptCause)
+		}
+
+		if len(scan.entries) == 0 {
+			if meta.IsCompactedSegment || meta.LastEntryIndex > meta.FirstEntryIndex {
+				issue("metadata lists entries %d-%d but the file has none", meta.FirstEntryIndex, meta.LastEntryIndex)
+			}
+			continue
+		}
+
+		var previous *walEntry
+		for j := range scan.entries {
+			entry := &scan.entries[j]
+			if previous != nil && entry.Index <= previous.Index {
+				issue("entry index %d does not follow %d", entry.Index, previous.Index)
+			}
+			if entry.Index < meta.FirstEntryIndex {
+				issue("entry index %d is below firstEntryIndex %d", entry.Index, meta.FirstEntryIndex)
+			}
+			previous = entry
+		}
+
+		first := scan.entries[0].Index
+		last := scan.entries[len(scan.entries)-1].Index
+		if meta.IsCompactedSegment {
+			if first != meta.FirstEntryIndex {
+				issue("firstEntryIndex is %d but the first entry is %d", meta.FirstEntryIndex, first)
+			}
+			if last != meta.LastEntryIndex {
+				issue("lastEntryIndex is %d but the last entry is %d", meta.LastEntryIndex, last)
+			}
+		} else if last+1 != meta.LastEntryIndex {
+			issue("lastEntryIndex is %d but the last entry is %d", meta.LastEntryIndex, last)
+		}
+	}
+
+	for _, file := range files {
+		if !referenced[file.path] {
+			issues = append(issues, FsckIssue{SegmentId: file.id, Path: file.path, Problem: "orphan segment file not listed in metadata"})
+		}
+	}
+
+	return issues, nil
+}
+
+func Repair(dir string) ([]FsckIssue, error) {
+	issues, err := Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	metaPath := filepath.Join(dir, "meta", "wal_metadata.dat")
+	previous, _ := readMetadataFile(metaPath)
+	previousById := make(map[string]*walSegmentMetadata)
+	if previous != nil {
+		for _, meta := range previous.SortedSegmentsMetadata {
+			previousById[meta.Id] = meta
+		}
+	}
+
+	files, err := listSegmentFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilt := &walMetadata{SortedSegmentsMetadata: []*walSegmentMetadata{}}
+	for _, file := range files {
+		old := previousById[file.id]
+
+		//the segments an interrupted compaction was rewriting are still on disk
+		if old != nil && old.IsCompactedSegment && !old.CompactionCompleted {
+			os.Remove(file.path)
+			continue
+		}
+
+		scan, err := scanSegmentFile(file.path)
+		if err != nil {
+			return issues, err
+		}
+
+		if scan.corruptAt != nil {
+			err = os.Truncate(file.path, scan.validLength)
+			if err != nil {
+				return issues, err
+			}
+		}
+
+		if len(scan.entries) == 0 {
+			os.Remove(file.path)
+			continue
+		}
+
+		first := scan.entries[0].Index
+		last := scan.entries[len(scan.entries)-1].Index
+
+		meta := &walSegmentMetadata{
+			SegmentIndex:    file.segmentIndex,
+			Id:              file.id,
+			FirstEntryIndex: first,
+			LastEntryIndex:  last + 1,
+		}
+
+		if old != nil {
+			meta.CreatedAt = old.CreatedAt
+			meta.Closed = old.Closed
+			meta.IsCompactedSegment = old.IsCompactedSegment
+			meta.CompactionCompleted = old.CompactionCompleted
+			meta.CompactedThrough = old.CompactedThrough
+			meta.Sessions = old.Sessions
+			if !old.IsCompactedSegment && old.FirstEntryIndex <= first {
+				meta.FirstEntryIndex = old.FirstEntryIndex
+			}
+		} else if info, err := os.Stat(file.path); err == nil {
+			meta.CreatedAt = info.ModTime()
+		}
+
+		if meta.IsCompactedSegment {
+			meta.LastEntryIndex = last
+		}
+
+		rebuilt.SortedSegmentsMetadata = append(rebuilt.SortedSegmentsMetadata, meta)
+	}
+
+	//a finished compaction that kept no entries has no file, its metadata alone carries the sessions
+	if previous != nil {
+		for _, meta := range previous.SortedSegmentsMetadata {
+			if meta.holdsNoEntries() && meta.CompactionCompleted {
+				rebuilt.SortedSegmentsMetadata = append(rebuilt.SortedSegmentsMetadata, meta)
+			}
+		}
+	}
+
+	rebuilt.sortMetadata()
+	count := len(rebuilt.SortedSegmentsMetadata)
+	for i, meta := range rebuilt.SortedSegmentsMetadata {
+		if i < count-1 || meta.isAtCapacity() {
+			meta.Closed = true
+		}
+	}
+
+	if _, err := os.Stat(metaPath); err == nil {
+		err = os.Rename(metaPath, metaPath+".bak")
+		if err != nil {
+			return issues, err
+		}
+	}
+
+	repaired := &wal{dir: dir, metatada: rebuilt}
+	repaired.saveMetadata()
+
+	return issues, nil
+}
+
+func readMetadataFile(path string) (*walMetadata, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metadata walMetadata
+	err = json.Unmarshal(bytes, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.sortMetadata()
+	return &metadata, nil
+}
+
+func listSegmentFiles(dir string) ([]segmentFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "wal_segment_*.wal"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []segmentFile{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "wal_segment_"), ".wal")
+		indexPart, id, found := strings.Cut(name, "_")
+		if !found {
+			continue
+		}
+
+		segmentIndex, err := strconv.ParseUint(indexPart, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, segmentFile{path: path, segmentIndex: segmentIndex, id: id})
+	}
+
+	return files, nil
+}
+
+func scanSegmentFile(path string) (*segmentFileScan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scan := &segmentFileScan{entries: []walEntry{}}
+	reader := bufio.NewReader(file)
+
+	for {
+		bytes, err := reader.ReadBytes('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			//a partial line at the end of the file is a torn write
+			if len(bytes) > 0 {
+				offset := scan.validLength
+				scan.corruptAt = &offset
+				scan.corruptCause = errors.New("incomplete entry")
+			}
+			break
+		}
+
+		var entry walEntry
+		err = json.Unmarshal(bytes, &entry)
+		if err == nil {
+			if key, _ := entry.keyValue(); key == nil && entry.EntryType != WalEntryTypeNoOp && entry.EntryType != WalEntryTypeConfigChange {
+				err = ErrWrongWalEntryType
+			}
+		}
+		if err != nil {
+			offset := scan.validLength
+			scan.corruptAt = &offset
+			scan.corruptCause = err
+			break
+		}
+
+		scan.entries = append(scan.entries, entry)
+		scan.validLength += int64(len(bytes))
+	}
+
+	return scan, nil
+}
@@ -0,0 +1,136 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type fsckSegments struct {
+	first  uint64
+	last   uint64
+	closed bool
+}
+
+func writeFsckStore(t *testing.T) (string, *walMetadata) {
+	dir := t.TempDir()
+	store := NewKvStore(dir)
+	//two full segments and an open one holding the rest
+	for i := 1; i <= 2*walSegmentSize+2; i++ {
+		store.Put("k"+strconv.Itoa(i), "v")
+	}
+	store.Close()
+
+	metadata, err := readMetadataFile(filepath.Join(dir, "meta", "wal_metadata.dat"))
+	if err != nil || len(metadata.SortedSegmentsMetadata) != 3 {
+		t.Fatalf("the store was written as %v %v", metadata, err)
+	}
+	return dir, metadata
+}
+
+func saveFsckMetadata(dir string, metadata *walMetadata) {
+	(&wal{dir: dir, metatada: metadata}).saveMetadata()
+}
+
+func TestFsck(t *testing.T) {
+	tests := []struct {
+		name     string
+		damage   func(t *testing.T, dir string, metadata *walMetadata)
+		problem  string
+		repaired []fsckSegments
+		lost     []string
+	}{
+		{
+			name: "listed segment file missing",
+			damage: func(t *testing.T, dir string, metadata *walMetadata) {
+				os.Remove(metadata.SortedSegmentsMetadata[1].segmentLogFilePath(dir))
+			},
+			problem:  "segment file missing",
+			repaired: []fsckSegments{{1, 6, true}, {11, 13, false}},
+			lost:     []string{"k6", "k7", "k8", "k9", "k10"},
+		},
+		{
+			name: "orphan segment file",
+			damage: func(t *testing.T, dir string, metadata *walMetadata) {
+				metadata.SortedSegmentsMetadata = append(metadata.SortedSegmentsMetadata[:1], metadata.SortedSegmentsMetadata[2:]...)
+				saveFsckMetadata(dir, metadata)
+			},
+			problem:  "orphan segment file not listed in metadata",
+			repaired: []fsckSegments{{1, 6, true}, {6, 11, true}, {11, 13, false}},
+		},
+		{
+			name: "torn trailing line",
+			damage: func(t *testing.T, dir string, metadata *walMetadata) {
+				file, err := os.OpenFile(metadata.SortedSegmentsMetadata[2].segmentLogFilePath(dir), os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				file.WriteString(`{"index":13,"da`)
+			},
+			problem:  "incomplete entry",
+			repaired: []fsckSegments{{1, 6, true}, {6, 11, true}, {11, 13, false}},
+		},
+		{
+			name: "entry indexes disagree with the file",
+			damage: func(t *testing.T, dir string, metadata *walMetadata) {
+				metadata.SortedSegmentsMetadata[1].LastEntryIndex = 9
+				saveFsckMetadata(dir, metadata)
+			},
+			problem:  "lastEntryIndex is 9 but the last entry is 10",
+			repaired: []fsckSegments{{1, 6, true}, {6, 11, true}, {11, 13, false}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, metadata := writeFsckStore(t)
+			test.damage(t, dir, metadata)
+
+			issues, err := Verify(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, issue := range issues {
+				found = found || strings.Contains(issue.Problem, test.problem)
+			}
+			if !found {
+				t.Fatalf("expected %q, verify found %v", test.problem, issues)
+			}
+
+			_, err = Repair(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repaired, err := readMetadataFile(filepath.Join(dir, "meta", "wal_metadata.dat"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []fsckSegments{}
+			for _, meta := range repaired.SortedSegmentsMetadata {
+				got = append(got, fsckSegments{meta.FirstEntryIndex, meta.LastEntryIndex, meta.Closed})
+			}
+			if !slices.Equal(got, test.repaired) {
+				t.Fatalf("repair wrote segments %v, expected %v", got, test.repaired)
+			}
+
+			//a repaired store is clean and serves everything that survived
+			issues, err = Verify(dir)
+			if err != nil || len(issues) != 0 {
+				t.Fatalf("after repair verify found %v %v", issues, err)
+			}
+			store := NewKvStore(dir)
+			defer store.Close()
+			for i := 1; i <= 2*walSegmentSize+2; i++ {
+				key := "k" + strconv.Itoa(i)
+				if value := store.Get(key); (value == nil) != slices.Contains(test.lost, key) {
+					t.Fatalf("%s after repair is %v", key, value)
+				}
+			}
+		})
+	}
+}
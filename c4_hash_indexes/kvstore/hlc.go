@@ -0,0 +1,107 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxClockOffset = 5 * time.Second
+
+var ErrClockOffset = errors.New("timestamp is further ahead of this node's clock than the allowed offset")
+var ErrInvalidTimestamp = errors.New("timestamps look like <wall nanoseconds>.<logical>")
+
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical"`
+}
+
+func (timestamp Timestamp) After(other Timestamp) bool {
+	if timestamp.Wall != other.Wall {
+		return timestamp.Wall > other.Wall
+	}
+	return timestamp.Logical > other.Logical
+}
+
+func (timestamp Timestamp) IsZero() bool {
+	return timestamp.Wall == 0 && timestamp.Logical == 0
+}
+
+func (timestamp Timestamp) String() string {
+	return fmt.Sprintf("%d.%d", timestamp.Wall, timestamp.Logical)
+}
+
+func ParseTimestamp(value string) (Timestamp, error) {
+	wall, logical, _ := strings.Cut(value, ".")
+	parsedWall, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil {
+		return Timestamp{}, ErrInvalidTimestamp
+	}
+	parsedLogical := uint64(0)
+	if logical != "" {
+		parsedLogical, err = strconv.ParseUint(logical, 10, 32)
+		if err != nil {
+			return Timestamp{}, ErrInvalidTimestamp
+		}
+	}
+	return Timestamp{Wall: parsedWall, Logical: uint32(parsedLogical)}, nil
+}
+
+type Clock struct {
+	last  Timestamp
+	mutex sync.Mutex
+}
+
+var nodeClock = &Clock{}
+
+func NodeClock() *Clock {
+	//every store in a process stamps from the same clock, so partitions and replicas on a node agree
+	return nodeClock
+}
+
+func (clock *Clock) Now() Timestamp {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	//the logical part only counts up while the wall clock stands still or is behind what was seen
+	wall := time.Now().UnixNano()
+	if wall > clock.last.Wall {
+		clock.last = Timestamp{Wall: wall}
+	} else {
+		clock.last.Logical++
+	}
+	return clock.last
+}
+
+func (clock *Clock) Update(remote Timestamp) (Timestamp, error) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	//a node whose clock is far ahead would drag every other clock along with it
+	wall := time.Now().UnixNano()
+	if remote.Wall-wall > int64(maxClockOffset) {
+		return clock.last, fmt.Errorf("%w: %s is %s ahead", ErrClockOffset, remote, time.Duration(remote.Wall-wall))
+	}
+
+	switch {
+	case wall > clock.last.Wall && wall > remote.Wall:
+		clock.last = Timestamp{Wall: wall}
+	case clock.last.Wall == remote.Wall:
+		clock.last.Logical = max(clock.last.Logical, remote.Logical) + 1
+	case clock.last.Wall > remote.Wall:
+		clock.last.Logical++
+	default:
+		clock.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	}
+	return clock.last, nil
+}
+
+func (wal *wal) observe(entry *walEntry) {
+	//entries written elsewhere move this clock past them, so later local writes order after them
+	if !entry.Hlc.IsZero() {
+		nodeClock.Update(entry.Hlc)
+	}
+}
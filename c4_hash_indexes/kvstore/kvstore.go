@@ -1,13 +1,33 @@
 package kvstore
 
+import (
+	"sync"
+	"time"
+)
+
 type KvStore struct {
-	wal wal
+	wal            *wal
+	feed           *changeFeed
+	watches        *watchRegistry
+	leaderUrl      string
+	replicatedTerm uint64
+	raft           *raftNode
+	sessions       *sessionTable
+	stopFollowing  func()
+	caughtUpAt     time.Time
+	followMutex    sync.Mutex
 }
 
-func NewKvStore() *KvStore {
+func NewKvStore(dir string) *KvStore {
 	store := KvStore{
-		wal: *newWal(),
+		wal:      newWal(dir),
+		feed:     newChangeFeed(),
+		watches:  newWatchRegistry(),
+		sessions: newSessionTable(),
 	}
+	store.wal.afterWrite = store.feed.publish
+	store.wal.admit = store.sessions.admit
+	store.wal.restoreSessions = store.sessions.restore
 
 	err := store.wal.loadHashIndex()
 	if err != nil {
@@ -17,39 +37,120 @@ func NewKvStore() *KvStore {
 	return &store
 }
 
-func (store *KvStore) Put(key string, value string) error {
+func (store *KvStore) LastIndex() uint64 {
+	return store.wal.lastIndex()
+}
+
+func (store *KvStore) Close() {
+	if store.raft != nil {
+		store.raft.shutdown()
+	}
+	store.StopFollowing()
+	store.wal.close()
+}
+
+func (store *KvStore) Put(key string, value string) (uint64, error) {
+	return store.PutIdempotent("", 0, key, value)
+}
+
+func (store *KvStore) PutIdempotent(clientId string, sequence uint64, key string, value string) (uint64, error) {
+	if store.IsFollower() {
+		return 0, ErrReadOnlyFollower
+	}
+
 	command := SetValueCommand{
 		Key:   key,
 		Value: value,
 	}
 	walEntry, err := command.toWalEntry()
 	if err != nil {
-		return err
+		return 0, err
 	}
+	walEntry.ClientId = clientId
+	walEntry.Sequence = sequence
 
-	store.wal.WriteEntry(&walEntry)
-	return nil
+	return store.writeEntry(walEntry)
 }
 
 func (store *KvStore) Get(key string) *string {
+	value, _ := store.GetVersion(key)
+	return value
+}
+
+func (store *KvStore) GetVersion(key string) (*string, Timestamp) {
 	entry := store.wal.GetEntry(key)
 	if entry == nil {
-		return nil
+		return nil, Timestamp{}
 	}
 
+	//the version is when the key was last written, deletes included
 	_, value := entry.keyValue()
-	return value
+	return value, entry.Hlc
+}
+
+func (store *KvStore) Delete(key string) (uint64, error) {
+	return store.DeleteIdempotent("", 0, key)
 }
 
-func (store *KvStore) Delete(key string) error {
+func (store *KvStore) DeleteIdempotent(clientId string, sequence uint64, key string) (uint64, error) {
+	if store.IsFollower() {
+		return 0, ErrReadOnlyFollower
+	}
+
 	command := DeleteValueCommand{
 		Key: key,
 	}
 	walEntry, err := command.toWalEntry()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	walEntry.ClientId = clientId
+	walEntry.Sequence = sequence
+
+	return store.writeEntry(walEntry)
+}
+
+func (store *KvStore) writeEntry(entry walEntry) (uint64, error) {
+	if entry.ClientId != "" {
+		if entry.Sequence == 0 {
+			return 0, ErrMissingSequence
+		}
+
+		//a retried request is answered from the session table instead of being written again
+		index, duplicate, err := store.sessions.lookup(entry.ClientId, entry.Sequence)
+		if err != nil || duplicate {
+			return index, err
+		}
+	}
+
+	//with raft the entry only becomes visible, and watches fire, once a majority has it
+	if store.raft != nil {
+		err := store.raft.propose([]*walEntry{&entry})
+		if err != nil {
+			return entry.Index, err
+		}
+		return store.appliedIndexFor(entry), nil
+	}
+
+	err := store.wal.WriteEntry(&entry, nil)
+	if err != nil {
+		return 0, err
 	}
 
-	store.wal.WriteEntry(&walEntry)
-	return nil
+	index := store.appliedIndexFor(entry)
+	if index == entry.Index {
+		store.watches.notify(entry.toChange())
+	}
+	return index, nil
+}
+
+func (store *KvStore) appliedIndexFor(entry walEntry) uint64 {
+	//a retry that raced its original is in the log but was never applied, answer with the original
+	if entry.ClientId != "" {
+		index, duplicate, _ := store.sessions.lookup(entry.ClientId, entry.Sequence)
+		if duplicate {
+			return index
+		}
+	}
+	return entry.Index
 }
@@ -0,0 +1,194 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+)
+
+const MerkleBuckets = 1024
+
+type MerkleScopes func(key string) []string
+
+type merkleIndex struct {
+	scopes MerkleScopes
+	//every bucket maps its keys to the hash of their key and value
+	buckets []map[string]uint64
+	leaves  map[string][]uint64
+}
+
+func newMerkleIndex(scopes MerkleScopes) *merkleIndex {
+	index := &merkleIndex{
+		scopes:  scopes,
+		buckets: make([]map[string]uint64, MerkleBuckets),
+		leaves:  make(map[string][]uint64),
+	}
+	for i := range index.buckets {
+		index.buckets[i] = make(map[string]uint64)
+	}
+	return index
+}
+
+func mix(hash uint64) uint64 {
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+func MerkleBucket(key string) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return int(mix(hash.Sum64()) % MerkleBuckets)
+}
+
+func entryHash(key string, value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	hash.Write([]byte{0})
+	hash.Write([]byte(value))
+	return mix(hash.Sum64())
+}
+
+func (index *merkleIndex) scopesOf(key string) []string {
+	//without scopes every key belongs to the one tree over the whole store
+	if index.scopes == nil {
+		return []string{""}
+	}
+	return index.scopes(key)
+}
+
+func (index *merkleIndex) toggle(key string, bucket int, hash uint64) {
+	//xor lets a key be taken out of a leaf again without looking at the rest of it
+	for _, scope := range index.scopesOf(key) {
+		leaves, exists := index.leaves[scope]
+		if !exists {
+			leaves = make([]uint64, MerkleBuckets)
+			index.leaves[scope] = leaves
+		}
+		leaves[bucket] ^= hash
+	}
+}
+
+func (index *merkleIndex) set(key string, value string) {
+	bucket := MerkleBucket(key)
+	if previous, exists := index.buckets[bucket][key]; exists {
+		index.toggle(key, bucket, previous)
+	}
+
+	hash := entryHash(key, value)
+	index.buckets[bucket][key] = hash
+	index.toggle(key, bucket, hash)
+}
+
+func (index *merkleIndex) remove(key string) {
+	bucket := MerkleBucket(key)
+	previous, exists := index.buckets[bucket][key]
+	if !exists {
+		return
+	}
+	index.toggle(key, bucket, previous)
+	delete(index.buckets[bucket], key)
+}
+
+func (index *merkleIndex) rescope(scopes MerkleScopes) {
+	index.scopes = scopes
+	index.leaves = make(map[string][]uint64)
+	for bucket, keys := range index.buckets {
+		for key, hash := range keys {
+			index.toggle(key, bucket, hash)
+		}
+	}
+}
+
+func (wal *wal) merkleKey(entry *walEntry) {
+	key, value := entry.keyValue()
+	if key == nil {
+		return
+	}
+	if value == nil {
+		wal.merkle.remove(*key)
+	} else {
+		wal.merkle.set(*key, *value)
+	}
+}
+
+type MerkleTree struct {
+	Levels [][]uint64 `json:"levels"`
+}
+
+func combine(left uint64, right uint64) uint64 {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], left)
+	binary.BigEndian.PutUint64(data[8:], right)
+	hash := fnv.New64a()
+	hash.Write(data[:])
+	return mix(hash.Sum64())
+}
+
+func (store *KvStore) SetMerkleScopes(scopes MerkleScopes) {
+	store.wal.mutex.Lock()
+	defer store.wal.mutex.Unlock()
+
+	store.wal.merkle.rescope(scopes)
+}
+
+func (store *KvStore) MerkleTree(scope string) MerkleTree {
+	store.wal.mutex.RLock()
+	leaves := make([]uint64, MerkleBuckets)
+	copy(leaves, store.wal.merkle.leaves[scope])
+	store.wal.mutex.RUnlock()
+
+	//the first level is the root, the last one the buckets
+	levels := [][]uint64{leaves}
+	for len(levels[0]) > 1 {
+		below := levels[0]
+		level := make([]uint64, len(below)/2)
+		for i := range level {
+			level[i] = combine(below[2*i], below[2*i+1])
+		}
+		levels = append([][]uint64{level}, levels...)
+	}
+	return MerkleTree{Levels: levels}
+}
+
+func (tree MerkleTree) Root() uint64 {
+	return tree.Levels[0][0]
+}
+
+func (tree MerkleTree) Hashes(level int, positions []int) []uint64 {
+	hashes := []uint64{}
+	if level < 0 || level >= len(tree.Levels) {
+		return hashes
+	}
+	for _, position := range positions {
+		if position >= 0 && position < len(tree.Levels[level]) {
+			hashes = append(hashes, tree.Levels[level][position])
+		}
+	}
+	return hashes
+}
+
+func (store *KvStore) BucketKeys(bucket int, scope string) []KeyValue {
+	store.wal.mutex.RLock()
+	keys := []string{}
+	if bucket >= 0 && bucket < MerkleBuckets {
+		for key := range store.wal.merkle.buckets[bucket] {
+			if store.wal.merkle.scopes == nil || slices.Contains(store.wal.merkle.scopes(key), scope) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	store.wal.mutex.RUnlock()
+
+	items := []KeyValue{}
+	for _, key := range keys {
+		value := store.Get(key)
+		if value != nil {
+			items = append(items, KeyValue{Key: key, Value: *value})
+		}
+	}
+	return items
+}
@@ -0,0 +1,205 @@
+package kvstore
+
+import "math/rand"
+
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+const (
+	orderedMaxLevel = 24
+	//each level holds about a quarter of the keys of the one below
+	orderedLevelOdds = 4
+)
+
+type orderedNode struct {
+	key  string
+	next []*orderedNode
+}
+
+type orderedKeys struct {
+	head  *orderedNode
+	level int
+	size  int
+}
+
+func newOrderedKeys() *orderedKeys {
+	return &orderedKeys{head: &orderedNode{next: make([]*orderedNode, orderedMaxLevel)}, level: 1}
+}
+
+func (index *orderedKeys) randomLevel() int {
+	level := 1
+	for level < orderedMaxLevel && rand.Intn(orderedLevelOdds) == 0 {
+		level++
+	}
+	return level
+}
+
+func (index *orderedKeys) precedingNodes(key string) []*orderedNode {
+	//the last node before the key on every level, which is where a new node would be linked in
+	preceding := make([]*orderedNode, orderedMaxLevel)
+	node := index.head
+	for level := index.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		preceding[level] = node
+	}
+	return preceding
+}
+
+func (index *orderedKeys) first(key string) *orderedNode {
+	//the first node at or past the key
+	node := index.head
+	for level := index.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+	}
+	return node.next[0]
+}
+
+func (index *orderedKeys) set(key string) {
+	preceding := index.precedingNodes(key)
+	if next := preceding[0].next[0]; next != nil && next.key == key {
+		return
+	}
+
+	level := index.randomLevel()
+	for ; index.level < level; index.level++ {
+		preceding[index.level] = index.head
+	}
+
+	node := &orderedNode{key: key, next: make([]*orderedNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = preceding[i].next[i]
+		preceding[i].next[i] = node
+	}
+	index.size++
+}
+
+func (index *orderedKeys) remove(key string) {
+	preceding := index.precedingNodes(key)
+	node := preceding[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := range node.next {
+		preceding[i].next[i] = node.next[i]
+	}
+	for index.level > 1 && index.head.next[index.level-1] == nil {
+		index.level--
+	}
+	index.size--
+}
+
+func (index *orderedKeys) walk(start string, end string, visit func(key string) bool) {
+	//an empty end means the range runs to the last key
+	for node := index.first(start); node != nil; node = node.next[0] {
+		if end != "" && node.key >= end {
+			return
+		}
+		if !visit(node.key) {
+			return
+		}
+	}
+}
+
+func (index *orderedKeys) between(start string, end string, limit int) []string {
+	keys := []string{}
+	index.walk(start, end, func(key string) bool {
+		keys = append(keys, key)
+		return limit <= 0 || len(keys) < limit
+	})
+	return keys
+}
+
+func (index *orderedKeys) count(start string, end string) int {
+	if start == "" && end == "" {
+		return index.size
+	}
+
+	count := 0
+	index.walk(start, end, func(key string) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func PrefixEnd(prefix string) string {
+	//the first key past every key with the prefix, or the end of the keyspace
+	end := []byte(prefix)
+	for len(end) > 0 {
+		last := len(end) - 1
+		if end[last] < 0xff {
+			end[last]++
+			return string(end)
+		}
+		end = end[:last]
+	}
+	return ""
+}
+
+func (wal *wal) indexKey(entry *walEntry) {
+	key, value := entry.keyValue()
+	if key == nil {
+		return
+	}
+	if value == nil {
+		wal.ordered.remove(*key)
+	} else {
+		wal.ordered.set(*key)
+	}
+}
+
+func (wal *wal) keysBetween(start string, end string, limit int) []string {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	return wal.ordered.between(start, end, limit)
+}
+
+func (store *KvStore) Scan(start string, end string, limit int) []KeyValue {
+	items := []KeyValue{}
+	for _, key := range store.wal.keysBetween(start, end, limit) {
+		//a key deleted since the keys were listed is left out
+		value := store.Get(key)
+		if value != nil {
+			items = append(items, KeyValue{Key: key, Value: *value})
+		}
+	}
+	return items
+}
+
+func (store *KvStore) ScanPrefix(prefix string, limit int) []KeyValue {
+	return store.Scan(prefix, PrefixEnd(prefix), limit)
+}
+
+func (store *KvStore) KeyCount(start string, end string) int {
+	store.wal.mutex.RLock()
+	defer store.wal.mutex.RUnlock()
+
+	return store.wal.ordered.count(start, end)
+}
+
+func (store *KvStore) MedianKey(start string, end string) (string, bool) {
+	store.wal.mutex.RLock()
+	defer store.wal.mutex.RUnlock()
+
+	count := store.wal.ordered.count(start, end)
+	if count == 0 {
+		return "", false
+	}
+
+	median := ""
+	seen := 0
+	store.wal.ordered.walk(start, end, func(key string) bool {
+		median = key
+		seen++
+		return seen <= count/2
+	})
+	return median, true
+}
@@ -0,0 +1,55 @@
+package kvstore
+
+import (
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestOrderedKeysMatchSortedKeys(t *testing.T) {
+	index := newOrderedKeys()
+	present := make(map[string]bool)
+
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(rand.Intn(1000))
+		if rand.Intn(3) == 0 {
+			index.remove(key)
+			delete(present, key)
+		} else {
+			index.set(key)
+			present[key] = true
+		}
+	}
+
+	expected := []string{}
+	for key := range present {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	if got := index.between("", "", 0); !slices.Equal(got, expected) {
+		t.Fatalf("keys are %v, expected %v", got, expected)
+	}
+	if index.count("", "") != len(expected) {
+		t.Fatalf("count is %d, expected %d", index.count("", ""), len(expected))
+	}
+
+	//a range starts at its first key and stops before its end
+	inRange := []string{}
+	for _, key := range expected {
+		if key >= "3" && key < "6" {
+			inRange = append(inRange, key)
+		}
+	}
+	if got := index.between("3", "6", 0); !slices.Equal(got, inRange) {
+		t.Fatalf("keys between 3 and 6 are %v, expected %v", got, inRange)
+	}
+	if index.count("3", "6") != len(inRange) {
+		t.Fatal("the count of a range does not match its keys")
+	}
+	if got := index.between("3", "6", 2); len(inRange) >= 2 && !slices.Equal(got, inRange[:2]) {
+		t.Fatalf("a limit of 2 gave %v", got)
+	}
+}
@@ -0,0 +1,887 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keyvault/cluster"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const RaftRequestVotePath = "/raft/request-vote"
+const RaftAppendEntriesPath = "/raft/append-entries"
+
+const (
+	raftTickInterval       = 50 * time.Millisecond
+	raftHeartbeatInterval  = 250 * time.Millisecond
+	raftMinElectionTimeout = 1 * time.Second
+	raftMaxElectionTimeout = 2 * time.Second
+	raftRpcTimeout         = 500 * time.Millisecond
+	raftCommitTimeout      = 5 * time.Second
+	raftMaxEntriesPerSend  = 100
+	//kept below the minimum election timeout, followers refuse votes for that long after hearing from the leader
+	raftLeaseDuration = 800 * time.Millisecond
+)
+
+var ErrNotLeader = errors.New("this node is not the raft leader")
+var ErrCommitTimeout = errors.New("write was not committed by a majority in time")
+var ErrLeadershipLost = errors.New("leadership was lost before the write was committed")
+var ErrRaftAlreadyEnabled = errors.New("raft is already enabled")
+var ErrRaftNotEnabled = errors.New("raft is not enabled on this node")
+var ErrStaleGeneration = errors.New("request is from an older generation")
+var ErrRaftStopped = errors.New("raft has been stopped on this node")
+
+type raftRole int
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+func (role raftRole) String() string {
+	switch role {
+	case raftFollower:
+		return "follower"
+	case raftCandidate:
+		return "candidate"
+	case raftLeader:
+		return "leader"
+	}
+	return "unknown"
+}
+
+type raftState struct {
+	CurrentTerm uint64      `json:"currentTerm"`
+	VotedFor    string      `json:"votedFor"`
+	CommitIndex uint64      `json:"commitIndex"`
+	Config      *raftConfig `json:"config,omitempty"`
+}
+
+type requestVoteRequest struct {
+	Term               uint64 `json:"term"`
+	CandidateId        string `json:"candidateId"`
+	LastLogIndex       uint64 `json:"lastLogIndex"`
+	LastLogTerm        uint64 `json:"lastLogTerm"`
+	LeadershipTransfer bool   `json:"leadershipTransfer,omitempty"`
+}
+
+type requestVoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+type appendEntriesRequest struct {
+	Term         uint64     `json:"term"`
+	LeaderId     string     `json:"leaderId"`
+	PrevLogIndex uint64     `json:"prevLogIndex"`
+	PrevLogTerm  uint64     `json:"prevLogTerm"`
+	Entries      []walEntry `json:"entries"`
+	LeaderCommit uint64     `json:"leaderCommit"`
+	TransferTo   string     `json:"transferTo,omitempty"`
+}
+
+type appendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	MatchIndex    uint64 `json:"matchIndex"`
+	ConflictIndex uint64 `json:"conflictIndex"`
+}
+
+type RaftStatus struct {
+	Id           string   `json:"id"`
+	Role         string   `json:"role"`
+	Term         uint64   `json:"term"`
+	LeaderId     string   `json:"leaderId"`
+	CommitIndex  uint64   `json:"commitIndex"`
+	LastIndex    uint64   `json:"lastIndex"`
+	Voters       []string `json:"voters"`
+	NewVoters    []string `json:"newVoters,omitempty"`
+	Learners     []string `json:"learners,omitempty"`
+	LowWaterMark uint64   `json:"lowWaterMark,omitempty"`
+}
+
+type raftNode struct {
+	store             *KvStore
+	selfId            string
+	config            raftConfig
+	initialConfig     raftConfig
+	pendingConfigs    []raftConfigEntry
+	state             raftState
+	role              raftRole
+	leaderId          string
+	electionDeadline  time.Time
+	lastHeartbeat     time.Time
+	lastLeaderContact time.Time
+	caughtUpAt        time.Time
+	nextIndex         map[string]uint64
+	matchIndex        map[string]uint64
+	replicating       map[string]bool
+	waiters           map[uint64][]chan error
+	termStartIndex    uint64
+	ackedAt           map[string]time.Time
+	acked             chan struct{}
+	transferringTo    string
+	leaderTransferTo  string
+	leaseVoidedAt     time.Time
+	client            *http.Client
+	stopped           bool
+	stop              chan struct{}
+	done              chan struct{}
+	mutex             sync.Mutex
+}
+
+func (store *KvStore) EnableRaft(selfId string, peers []cluster.Peer) error {
+	config := raftConfig{Voters: peers}
+	if !config.isVoter(selfId) {
+		config.Voters = append(config.Voters, cluster.Peer{Id: selfId})
+	}
+	return store.enableRaft(selfId, config)
+}
+
+func (store *KvStore) JoinRaft(selfId string) error {
+	//a joining node has no voters of its own, it waits for the leader to send it a configuration
+	return store.enableRaft(selfId, raftConfig{Voters: []cluster.Peer{}})
+}
+
+func (store *KvStore) enableRaft(selfId string, initialConfig raftConfig) error {
+	if store.raft != nil {
+		return ErrRaftAlreadyEnabled
+	}
+
+	raft := &raftNode{
+		store:         store,
+		selfId:        selfId,
+		initialConfig: initialConfig,
+		nextIndex:     make(map[string]uint64),
+		matchIndex:    make(map[string]uint64),
+		replicating:   make(map[string]bool),
+		waiters:       make(map[uint64][]chan error),
+		ackedAt:       make(map[string]time.Time),
+		acked:         make(chan struct{}),
+		client:        &http.Client{Timeout: raftRpcTimeout},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	err := raft.loadState()
+	if err != nil {
+		return err
+	}
+
+	//once a configuration has been committed it wins over the one from the command line
+	raft.config = raft.committedConfig()
+
+	//entries past the commit index stay invisible until a leader confirms them
+	store.sessions.reset()
+	err = store.wal.deferIndexingAfter(raft.state.CommitIndex)
+	if err != nil {
+		return err
+	}
+
+	err = raft.loadPendingConfigs()
+	if err != nil {
+		return err
+	}
+
+	//the log is only compacted below what every follower in reach has acknowledged
+	store.wal.mutex.Lock()
+	store.wal.retainFrom = raft.retainFrom
+	store.wal.mutex.Unlock()
+
+	raft.resetElectionDeadline()
+	store.raft = raft
+	go raft.run()
+	return nil
+}
+
+func (store *KvStore) RaftStatus() *RaftStatus {
+	if store.raft == nil {
+		return nil
+	}
+	return store.raft.status()
+}
+
+func (store *KvStore) IsLeader() bool {
+	if store.raft == nil {
+		return !store.IsFollower()
+	}
+
+	store.raft.mutex.Lock()
+	defer store.raft.mutex.Unlock()
+
+	return store.raft.role == raftLeader
+}
+
+func (store *KvStore) Generation() uint64 {
+	if store.raft == nil {
+		return 0
+	}
+
+	store.raft.mutex.Lock()
+	defer store.raft.mutex.Unlock()
+
+	return store.raft.state.CurrentTerm
+}
+
+func (store *KvStore) CheckGeneration(generation uint64) error {
+	if store.raft == nil {
+		return nil
+	}
+
+	raft := store.raft
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if generation < raft.state.CurrentTerm {
+		return fmt.Errorf("%w: %d, current generation is %d", ErrStaleGeneration, generation, raft.state.CurrentTerm)
+	}
+
+	//the generation is the raft term, a caller that saw a newer one has talked to a newer leader
+	//a header is not a raft message though, the term and role only change when a peer says so
+	if generation > raft.state.CurrentTerm {
+		return fmt.Errorf("%w: generation %d was seen, this node is at %d", ErrNotLeader, generation, raft.state.CurrentTerm)
+	}
+
+	return nil
+}
+
+func (raft *raftNode) statePath() string {
+	return filepath.Join(raft.store.wal.dir, "meta", "raft_state.dat")
+}
+
+func (raft *raftNode) loadState() error {
+	bytes, err := os.ReadFile(raft.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, &raft.state)
+}
+
+func (raft *raftNode) saveState() {
+	//the store may already be gone, nothing is written for a node that was shut down
+	if raft.stopped {
+		return
+	}
+
+	data, err := json.Marshal(raft.state)
+	if err != nil {
+		panic(err)
+	}
+
+	//a vote must never be forgotten, so the state is replaced atomically
+	os.MkdirAll(filepath.Join(raft.store.wal.dir, "meta"), 0755)
+	tempPath := raft.statePath() + ".tmp"
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		panic(err)
+	}
+	err = os.Rename(tempPath, raft.statePath())
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (raft *raftNode) status() *RaftStatus {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	status := &RaftStatus{
+		Id:          raft.selfId,
+		Role:        raft.role.String(),
+		Term:        raft.state.CurrentTerm,
+		LeaderId:    raft.leaderId,
+		CommitIndex: raft.state.CommitIndex,
+		LastIndex:   raft.store.wal.lastIndex(),
+		Voters:      peerIds(raft.config.Voters),
+	}
+	if raft.config.isJoint() {
+		status.NewVoters = peerIds(raft.config.NewVoters)
+	}
+	if len(raft.config.Learners) > 0 {
+		status.Learners = peerIds(raft.config.Learners)
+	}
+	if raft.role == raftLeader {
+		status.LowWaterMark = raft.lowWaterMark()
+	}
+	return status
+}
+
+func (raft *raftNode) leaderAddress() string {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	for _, peer := range raft.config.members() {
+		if peer.Id == raft.leaderId {
+			return peer.Address
+		}
+	}
+	return ""
+}
+
+func (raft *raftNode) peers() []cluster.Peer {
+	peers := []cluster.Peer{}
+	for _, peer := range raft.config.members() {
+		if peer.Id != raft.selfId {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (raft *raftNode) resetElectionDeadline() {
+	spread := int64(raftMaxElectionTimeout - raftMinElectionTimeout)
+	raft.electionDeadline = time.Now().Add(raftMinElectionTimeout + time.Duration(rand.Int63n(spread)))
+}
+
+func (raft *raftNode) termAt(index uint64) uint64 {
+	if index == 0 {
+		return 0
+	}
+
+	//terms are kept in memory as runs, so no segment is read under the raft lock
+	return raft.store.wal.termAt(index)
+}
+
+func (raft *raftNode) run() {
+	defer close(raft.done)
+
+	ticker := time.NewTicker(raftTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			raft.tick()
+		case <-raft.stop:
+			return
+		}
+	}
+}
+
+func (raft *raftNode) shutdown() {
+	raft.mutex.Lock()
+	if raft.stopped {
+		raft.mutex.Unlock()
+		return
+	}
+	raft.stopped = true
+	raft.mutex.Unlock()
+
+	close(raft.stop)
+	<-raft.done
+
+	//as a follower it sends nothing, replies still in flight only find a node that has stepped down
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+	raft.role = raftFollower
+	raft.leaderId = ""
+	raft.failWaiters(ErrRaftStopped)
+}
+
+func (raft *raftNode) tick() {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	now := time.Now()
+	if raft.role == raftLeader {
+		if now.Sub(raft.lastHeartbeat) >= raftHeartbeatInterval {
+			raft.lastHeartbeat = now
+			raft.replicateToAll()
+		}
+		return
+	}
+
+	//nodes outside the configuration, or still waiting to join, never campaign
+	if now.After(raft.electionDeadline) && raft.config.isVoter(raft.selfId) {
+		raft.startElection(false)
+	}
+}
+
+func (raft *raftNode) becomeFollower(term uint64) {
+	if term > raft.state.CurrentTerm {
+		raft.state.CurrentTerm = term
+		raft.state.VotedFor = ""
+		raft.leaderTransferTo = ""
+		raft.saveState()
+	}
+
+	if raft.role == raftLeader {
+		raft.failWaiters(ErrLeadershipLost)
+	}
+	raft.role = raftFollower
+}
+
+func (raft *raftNode) startElection(leadershipTransfer bool) {
+	raft.state.CurrentTerm++
+	raft.state.VotedFor = raft.selfId
+	raft.saveState()
+
+	raft.role = raftCandidate
+	raft.leaderId = ""
+	raft.resetElectionDeadline()
+
+	lastIndex := raft.store.wal.lastIndex()
+	request := requestVoteRequest{
+		Term:         raft.state.CurrentTerm,
+		CandidateId:  raft.selfId,
+		LastLogIndex: lastIndex,
+		LastLogTerm:  raft.termAt(lastIndex),
+
+		LeadershipTransfer: leadershipTransfer,
+	}
+
+	votes := map[string]bool{raft.selfId: true}
+	if raft.config.hasQuorum(func(id string) bool { return votes[id] }) {
+		raft.becomeLeader()
+		return
+	}
+
+	for _, peer := range raft.peers() {
+		if !raft.config.isVoter(peer.Id) {
+			continue
+		}
+
+		go func(peer cluster.Peer) {
+			var response requestVoteResponse
+			err := raft.call(peer, RaftRequestVotePath, request, &response)
+			if err != nil {
+				return
+			}
+
+			raft.mutex.Lock()
+			defer raft.mutex.Unlock()
+
+			if response.Term > raft.state.CurrentTerm {
+				raft.becomeFollower(response.Term)
+				return
+			}
+
+			if raft.role != raftCandidate || raft.state.CurrentTerm != request.Term || !response.VoteGranted {
+				return
+			}
+
+			votes[peer.Id] = true
+			if raft.config.hasQuorum(func(id string) bool { return votes[id] }) {
+				raft.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (raft *raftNode) becomeLeader() {
+	raft.role = raftLeader
+	raft.leaderId = raft.selfId
+
+	lastIndex := raft.store.wal.lastIndex()
+	for _, peer := range raft.peers() {
+		raft.nextIndex[peer.Id] = lastIndex + 1
+		raft.matchIndex[peer.Id] = 0
+	}
+
+	//entries from earlier terms are only committed once an entry of this term is
+	noOp := &walEntry{EntryType: WalEntryTypeNoOp}
+	err := raft.appendAsLeader([]*walEntry{noOp})
+	if err != nil {
+		raft.becomeFollower(raft.state.CurrentTerm)
+		return
+	}
+	raft.termStartIndex = noOp.Index
+	raft.ackedAt = make(map[string]time.Time)
+
+	raft.lastHeartbeat = time.Now()
+	raft.advanceCommitIndex()
+	raft.replicateToAll()
+}
+
+func (raft *raftNode) propose(entries []*walEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	raft.mutex.Lock()
+	if raft.role != raftLeader {
+		raft.mutex.Unlock()
+		return ErrNotLeader
+	}
+	if raft.transferringTo != "" {
+		raft.mutex.Unlock()
+		return ErrTransferInProgress
+	}
+
+	err := raft.appendAsLeader(entries)
+	if err != nil {
+		raft.mutex.Unlock()
+		return err
+	}
+
+	//the batch is done once its last entry is committed
+	lastIndex := entries[len(entries)-1].Index
+	waiter := raft.waitFor(lastIndex)
+
+	raft.advanceCommitIndex()
+	raft.replicateToAll()
+	raft.mutex.Unlock()
+
+	return raft.awaitCommit(lastIndex, waiter)
+}
+
+func (raft *raftNode) appendAsLeader(entries []*walEntry) error {
+	for _, entry := range entries {
+		entry.Term = raft.state.CurrentTerm
+	}
+
+	err := raft.store.wal.WriteEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	//a configuration takes effect as soon as it is in the log, not when it commits
+	for _, entry := range entries {
+		if entry.EntryType == WalEntryTypeConfigChange {
+			raft.configAppended(*entry)
+		}
+	}
+	return nil
+}
+
+func (raft *raftNode) waitFor(index uint64) chan error {
+	//buffered so a waiter that already gave up never blocks the commit
+	waiter := make(chan error, 1)
+	raft.waiters[index] = append(raft.waiters[index], waiter)
+	return waiter
+}
+
+func (raft *raftNode) awaitCommit(lastIndex uint64, waiter chan error) error {
+	timeout := time.NewTimer(raftCommitTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-waiter:
+		return err
+	case <-timeout.C:
+		return ErrCommitTimeout
+	}
+}
+
+func (raft *raftNode) failWaiters(err error) {
+	for index, waiters := range raft.waiters {
+		for _, waiter := range waiters {
+			waiter <- err
+		}
+		delete(raft.waiters, index)
+	}
+}
+
+func (raft *raftNode) advanceCommitIndex() {
+	lastIndex := raft.store.wal.lastIndex()
+
+	for index := lastIndex; index > raft.state.CommitIndex; index-- {
+		replicated := func(id string) bool {
+			return id == raft.selfId || raft.matchIndex[id] >= index
+		}
+
+		if !raft.config.hasQuorum(replicated) {
+			continue
+		}
+
+		//terms only grow along the log, so nothing lower can be from this term either
+		if raft.termAt(index) == raft.state.CurrentTerm {
+			raft.commitThrough(index)
+		}
+		return
+	}
+}
+
+func (raft *raftNode) commitThrough(index uint64) {
+	if index <= raft.state.CommitIndex {
+		return
+	}
+
+	raft.state.CommitIndex = index
+	for len(raft.pendingConfigs) > 0 && raft.pendingConfigs[0].index <= index {
+		config := raft.pendingConfigs[0].config
+		raft.state.Config = &config
+		raft.pendingConfigs = raft.pendingConfigs[1:]
+	}
+	raft.saveState()
+
+	for _, entry := range raft.store.wal.applyThrough(index) {
+		raft.store.watches.notify(entry.toChange())
+	}
+
+	for waiterIndex, waiters := range raft.waiters {
+		if waiterIndex <= index {
+			for _, waiter := range waiters {
+				waiter <- nil
+			}
+			delete(raft.waiters, waiterIndex)
+		}
+	}
+
+	if raft.role == raftLeader {
+		raft.finishConfigChange()
+	}
+}
+
+func (raft *raftNode) replicateToAll() {
+	for _, peer := range raft.peers() {
+		go raft.replicateTo(peer)
+	}
+}
+
+func (raft *raftNode) replicateTo(peer cluster.Peer) {
+	raft.mutex.Lock()
+	if raft.role != raftLeader || raft.replicating[peer.Id] {
+		raft.mutex.Unlock()
+		return
+	}
+	raft.replicating[peer.Id] = true
+
+	nextIndex := raft.nextIndex[peer.Id]
+
+	//entries the follower still needs were compacted away, only a snapshot can catch it up
+	if nextIndex <= raft.store.wal.compactedThrough() {
+		raft.mutex.Unlock()
+		go raft.sendSnapshot(peer)
+		return
+	}
+
+	request := appendEntriesRequest{
+		Term:         raft.state.CurrentTerm,
+		LeaderId:     raft.selfId,
+		PrevLogIndex: nextIndex - 1,
+		PrevLogTerm:  raft.termAt(nextIndex - 1),
+		Entries:      raft.store.wal.entriesFrom(nextIndex, raftMaxEntriesPerSend),
+		LeaderCommit: raft.state.CommitIndex,
+		TransferTo:   raft.transferringTo,
+	}
+	raft.mutex.Unlock()
+
+	sentAt := time.Now()
+	var response appendEntriesResponse
+	err := raft.call(peer, RaftAppendEntriesPath, request, &response)
+
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+	raft.replicating[peer.Id] = false
+
+	if err != nil {
+		return
+	}
+
+	if response.Term > raft.state.CurrentTerm {
+		raft.becomeFollower(response.Term)
+		return
+	}
+
+	if raft.role != raftLeader || raft.state.CurrentTerm != request.Term {
+		return
+	}
+	raft.acknowledged(peer.Id, sentAt)
+
+	if response.Success {
+		if response.MatchIndex > raft.matchIndex[peer.Id] {
+			raft.matchIndex[peer.Id] = response.MatchIndex
+		}
+		raft.nextIndex[peer.Id] = raft.matchIndex[peer.Id] + 1
+		raft.advanceCommitIndex()
+
+		if raft.nextIndex[peer.Id] <= raft.store.wal.lastIndex() {
+			go raft.replicateTo(peer)
+		}
+		return
+	}
+
+	//back off to where the follower says the logs start to differ
+	if response.ConflictIndex > 0 && response.ConflictIndex < nextIndex {
+		raft.nextIndex[peer.Id] = response.ConflictIndex
+	} else if nextIndex > 1 {
+		raft.nextIndex[peer.Id] = nextIndex - 1
+	}
+	go raft.replicateTo(peer)
+}
+
+func (raft *raftNode) call(peer cluster.Peer, path string, request any, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	httpResponse, err := raft.client.Post(peer.Address+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", peer.Id, httpResponse.Status)
+	}
+
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (raft *raftNode) handleRequestVote(request requestVoteRequest) requestVoteResponse {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if raft.stopped || request.Term < raft.state.CurrentTerm {
+		return requestVoteResponse{Term: raft.state.CurrentTerm}
+	}
+
+	//while a leader is heard from, a removed member that keeps campaigning must not depose it,
+	//unless it is the node that leader named as the target of a transfer
+	transferTo := raft.leaderTransferTo
+	if raft.role == raftLeader {
+		transferTo = raft.transferringTo
+	}
+	transfer := request.LeadershipTransfer && transferTo != "" && request.CandidateId == transferTo
+	heardFromLeader := raft.role == raftLeader || time.Since(raft.lastLeaderContact) < raftMinElectionTimeout
+	if heardFromLeader && raft.leaderId != "" && !transfer {
+		return requestVoteResponse{Term: raft.state.CurrentTerm}
+	}
+
+	if request.Term > raft.state.CurrentTerm {
+		raft.becomeFollower(request.Term)
+	}
+
+	//only vote for candidates whose log is at least as complete as ours
+	lastIndex := raft.store.wal.lastIndex()
+	lastTerm := raft.termAt(lastIndex)
+	upToDate := request.LastLogTerm > lastTerm || (request.LastLogTerm == lastTerm && request.LastLogIndex >= lastIndex)
+
+	canVote := raft.state.VotedFor == "" || raft.state.VotedFor == request.CandidateId
+	if !canVote || !upToDate {
+		return requestVoteResponse{Term: raft.state.CurrentTerm}
+	}
+
+	raft.state.VotedFor = request.CandidateId
+	raft.saveState()
+	raft.resetElectionDeadline()
+
+	return requestVoteResponse{Term: raft.state.CurrentTerm, VoteGranted: true}
+}
+
+func (raft *raftNode) handleAppendEntries(request appendEntriesRequest) (appendEntriesResponse, error) {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if raft.stopped {
+		return appendEntriesResponse{}, ErrRaftStopped
+	}
+
+	if request.Term < raft.state.CurrentTerm {
+		return appendEntriesResponse{Term: raft.state.CurrentTerm}, nil
+	}
+
+	raft.becomeFollower(request.Term)
+	raft.leaderId = request.LeaderId
+	raft.leaderTransferTo = request.TransferTo
+	raft.lastLeaderContact = time.Now()
+	raft.resetElectionDeadline()
+
+	response := appendEntriesResponse{Term: raft.state.CurrentTerm}
+	wal := raft.store.wal
+	lastIndex := wal.lastIndex()
+
+	if request.PrevLogIndex > lastIndex {
+		response.ConflictIndex = lastIndex + 1
+		return response, nil
+	}
+
+	//committed entries always match the leader's, anything after the commit index may not
+	if request.PrevLogIndex > raft.state.CommitIndex && raft.termAt(request.PrevLogIndex) != request.PrevLogTerm {
+		response.ConflictIndex = raft.state.CommitIndex + 1
+		return response, nil
+	}
+
+	for _, entry := range request.Entries {
+		if entry.Index <= raft.state.CommitIndex {
+			continue
+		}
+
+		if entry.Index <= lastIndex {
+			if raft.termAt(entry.Index) == entry.Term {
+				continue
+			}
+
+			err := wal.truncateFrom(entry.Index)
+			if err != nil {
+				return response, err
+			}
+			raft.configTruncated(entry.Index)
+			lastIndex = entry.Index - 1
+		}
+
+		err := wal.WriteEntry(&entry, &entry.Index)
+		if err != nil {
+			return response, err
+		}
+		if entry.EntryType == WalEntryTypeConfigChange {
+			raft.configAppended(entry)
+		}
+		lastIndex = entry.Index
+	}
+
+	response.Success = true
+	response.MatchIndex = request.PrevLogIndex
+	if len(request.Entries) > 0 {
+		response.MatchIndex = request.Entries[len(request.Entries)-1].Index
+	}
+
+	if request.LeaderCommit > raft.state.CommitIndex {
+		commitIndex := request.LeaderCommit
+		if commitIndex > response.MatchIndex {
+			commitIndex = response.MatchIndex
+		}
+		raft.commitThrough(commitIndex)
+	}
+
+	//everything the leader had committed when it sent this is applied here now
+	if raft.state.CommitIndex >= request.LeaderCommit {
+		raft.caughtUpAt = raft.lastLeaderContact
+	}
+
+	return response, nil
+}
+
+func (store *KvStore) HandleRequestVote(body []byte) ([]byte, error) {
+	if store.raft == nil {
+		return nil, ErrRaftNotEnabled
+	}
+
+	var request requestVoteRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(store.raft.handleRequestVote(request))
+}
+
+func (store *KvStore) HandleAppendEntries(body []byte) ([]byte, error) {
+	if store.raft == nil {
+		return nil, ErrRaftNotEnabled
+	}
+
+	var request appendEntriesRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := store.raft.handleAppendEntries(request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
@@ -0,0 +1,346 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"keyvault/cluster"
+	"log"
+	"math"
+)
+
+var ErrConfigChangeInProgress = errors.New("a membership change is already in progress")
+var ErrMemberExists = errors.New("node is already a member")
+var ErrUnknownMember = errors.New("node is not a member")
+var ErrLastMember = errors.New("cannot remove the last member")
+var ErrNotLearner = errors.New("node is not a learner")
+var ErrLearnerBehind = errors.New("learner has not caught up with the leader yet")
+
+type raftConfig struct {
+	Voters    []cluster.Peer `json:"voters"`
+	NewVoters []cluster.Peer `json:"newVoters,omitempty"`
+	Learners  []cluster.Peer `json:"learners,omitempty"`
+}
+
+type raftConfigEntry struct {
+	index  uint64
+	config raftConfig
+}
+
+type Membership struct {
+	Voters    []cluster.Peer `json:"voters"`
+	NewVoters []cluster.Peer `json:"newVoters,omitempty"`
+	Learners  []cluster.Peer `json:"learners,omitempty"`
+}
+
+func (config raftConfig) isJoint() bool {
+	return config.NewVoters != nil
+}
+
+func (config raftConfig) voters() []cluster.Peer {
+	return append(append([]cluster.Peer{}, config.Voters...), config.NewVoters...)
+}
+
+func (config raftConfig) members() []cluster.Peer {
+	//learners are replicated to like everyone else, they just never vote
+	members := []cluster.Peer{}
+	seen := make(map[string]bool)
+	for _, peer := range append(config.voters(), config.Learners...) {
+		if !seen[peer.Id] {
+			seen[peer.Id] = true
+			members = append(members, peer)
+		}
+	}
+	return members
+}
+
+func (config raftConfig) isVoter(id string) bool {
+	return hasPeer(config.voters(), id)
+}
+
+func (config raftConfig) isLearner(id string) bool {
+	return hasPeer(config.Learners, id)
+}
+
+func hasPeer(peers []cluster.Peer, id string) bool {
+	for _, peer := range peers {
+		if peer.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutPeer(peers []cluster.Peer, id string) []cluster.Peer {
+	remaining := []cluster.Peer{}
+	for _, peer := range peers {
+		if peer.Id != id {
+			remaining = append(remaining, peer)
+		}
+	}
+	return remaining
+}
+
+func quorumOf(voters []cluster.Peer, has func(id string) bool) bool {
+	count := 0
+	for _, peer := range voters {
+		if has(peer.Id) {
+			count++
+		}
+	}
+	return len(voters) > 0 && count*2 > len(voters)
+}
+
+func (config raftConfig) hasQuorum(has func(id string) bool) bool {
+	//during a joint change both the old and the new voters have to agree
+	if !quorumOf(config.Voters, has) {
+		return false
+	}
+	return !config.isJoint() || quorumOf(config.NewVoters, has)
+}
+
+func peerIds(peers []cluster.Peer) []string {
+	ids := []string{}
+	for _, peer := range peers {
+		ids = append(ids, peer.Id)
+	}
+	return ids
+}
+
+func (raft *raftNode) committedConfig() raftConfig {
+	if raft.state.Config != nil {
+		return *raft.state.Config
+	}
+	return raft.initialConfig
+}
+
+func (raft *raftNode) loadPendingConfigs() error {
+	entries := raft.store.wal.entriesFrom(raft.state.CommitIndex+1, math.MaxInt)
+	for _, entry := range entries {
+		if entry.EntryType == WalEntryTypeConfigChange {
+			raft.configAppended(entry)
+		}
+	}
+	return nil
+}
+
+func (raft *raftNode) configAppended(entry walEntry) {
+	var config raftConfig
+	err := json.Unmarshal(entry.Data, &config)
+	if err != nil {
+		log.Printf("raft: ignoring unreadable configuration at %d: %v", entry.Index, err)
+		return
+	}
+
+	raft.pendingConfigs = append(raft.pendingConfigs, raftConfigEntry{index: entry.Index, config: config})
+	raft.config = config
+
+	if raft.role == raftLeader {
+		for _, peer := range raft.peers() {
+			if _, exists := raft.nextIndex[peer.Id]; !exists {
+				raft.nextIndex[peer.Id] = entry.Index
+				raft.matchIndex[peer.Id] = 0
+			}
+		}
+	}
+}
+
+func (raft *raftNode) configTruncated(index uint64) {
+	pending := []raftConfigEntry{}
+	for _, entry := range raft.pendingConfigs {
+		if entry.index < index {
+			pending = append(pending, entry)
+		}
+	}
+	raft.pendingConfigs = pending
+
+	raft.config = raft.committedConfig()
+	if len(pending) > 0 {
+		raft.config = pending[len(pending)-1].config
+	}
+}
+
+func (raft *raftNode) finishConfigChange() {
+	if len(raft.pendingConfigs) > 0 {
+		return
+	}
+
+	//once the joint configuration commits the leader moves everyone on to the new one
+	committed := raft.committedConfig()
+	if committed.isJoint() {
+		data, _ := json.Marshal(raftConfig{Voters: committed.NewVoters, Learners: committed.Learners})
+		final := &walEntry{EntryType: WalEntryTypeConfigChange, Data: data}
+		err := raft.appendAsLeader([]*walEntry{final})
+		if err != nil {
+			log.Printf("raft: appending the new configuration: %v", err)
+			return
+		}
+
+		raft.advanceCommitIndex()
+		raft.replicateToAll()
+		return
+	}
+
+	//a leader that removed itself hands over once the change is committed
+	if !raft.config.isVoter(raft.selfId) {
+		raft.becomeFollower(raft.state.CurrentTerm)
+		raft.leaderId = ""
+	}
+}
+
+func (raft *raftNode) changeMembers(change func(config raftConfig) (raftConfig, error)) error {
+	raft.mutex.Lock()
+	if raft.role != raftLeader {
+		raft.mutex.Unlock()
+		return ErrNotLeader
+	}
+	if raft.config.isJoint() || len(raft.pendingConfigs) > 0 {
+		raft.mutex.Unlock()
+		return ErrConfigChangeInProgress
+	}
+
+	target, err := change(raft.config)
+	if err != nil {
+		raft.mutex.Unlock()
+		return err
+	}
+
+	//learners do not count towards quorum, so only a change of voters goes through a joint configuration
+	next := target
+	if !samePeers(raft.config.Voters, target.Voters) {
+		next = raftConfig{Voters: raft.config.Voters, NewVoters: target.Voters, Learners: target.Learners}
+	}
+
+	data, _ := json.Marshal(next)
+	entry := &walEntry{EntryType: WalEntryTypeConfigChange, Data: data}
+	err = raft.appendAsLeader([]*walEntry{entry})
+	if err != nil {
+		raft.mutex.Unlock()
+		return err
+	}
+
+	waiter := raft.waitFor(entry.Index)
+	raft.advanceCommitIndex()
+	raft.replicateToAll()
+	raft.mutex.Unlock()
+
+	err = raft.awaitCommit(entry.Index, waiter)
+	if err != nil {
+		return err
+	}
+
+	//committing the joint configuration made the leader append the final one
+	raft.mutex.Lock()
+	if len(raft.pendingConfigs) == 0 {
+		raft.mutex.Unlock()
+		return nil
+	}
+	finalIndex := raft.pendingConfigs[len(raft.pendingConfigs)-1].index
+	waiter = raft.waitFor(finalIndex)
+	raft.mutex.Unlock()
+
+	return raft.awaitCommit(finalIndex, waiter)
+}
+
+func samePeers(a []cluster.Peer, b []cluster.Peer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, peer := range a {
+		if !hasPeer(b, peer.Id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (store *KvStore) AddMember(peer cluster.Peer) error {
+	if store.raft == nil {
+		return ErrRaftNotEnabled
+	}
+
+	return store.raft.changeMembers(func(config raftConfig) (raftConfig, error) {
+		if hasPeer(config.members(), peer.Id) {
+			return config, ErrMemberExists
+		}
+		config.Voters = append(append([]cluster.Peer{}, config.Voters...), peer)
+		return config, nil
+	})
+}
+
+func (store *KvStore) AddLearner(peer cluster.Peer) error {
+	if store.raft == nil {
+		return ErrRaftNotEnabled
+	}
+
+	return store.raft.changeMembers(func(config raftConfig) (raftConfig, error) {
+		if hasPeer(config.members(), peer.Id) {
+			return config, ErrMemberExists
+		}
+		config.Learners = append(append([]cluster.Peer{}, config.Learners...), peer)
+		return config, nil
+	})
+}
+
+func (store *KvStore) PromoteLearner(id string) error {
+	if store.raft == nil {
+		return ErrRaftNotEnabled
+	}
+
+	raft := store.raft
+	return raft.changeMembers(func(config raftConfig) (raftConfig, error) {
+		if !config.isLearner(id) {
+			return config, ErrNotLearner
+		}
+
+		//an empty log would hold back every commit, so the learner has to catch up first
+		if raft.matchIndex[id]+raftMaxEntriesPerSend < raft.store.wal.lastIndex() {
+			return config, ErrLearnerBehind
+		}
+
+		for _, peer := range config.Learners {
+			if peer.Id == id {
+				config.Voters = append(append([]cluster.Peer{}, config.Voters...), peer)
+			}
+		}
+		config.Learners = withoutPeer(config.Learners, id)
+		return config, nil
+	})
+}
+
+func (store *KvStore) RemoveMember(id string) error {
+	if store.raft == nil {
+		return ErrRaftNotEnabled
+	}
+
+	return store.raft.changeMembers(func(config raftConfig) (raftConfig, error) {
+		if config.isLearner(id) {
+			config.Learners = withoutPeer(config.Learners, id)
+			return config, nil
+		}
+
+		remaining := withoutPeer(config.Voters, id)
+		if len(remaining) == len(config.Voters) {
+			return config, ErrUnknownMember
+		}
+		if len(remaining) == 0 {
+			return config, ErrLastMember
+		}
+		config.Voters = remaining
+		return config, nil
+	})
+}
+
+func (store *KvStore) Membership() *Membership {
+	if store.raft == nil {
+		return nil
+	}
+
+	store.raft.mutex.Lock()
+	defer store.raft.mutex.Unlock()
+
+	return &Membership{
+		Voters:    store.raft.config.Voters,
+		NewVoters: store.raft.config.NewVoters,
+		Learners:  store.raft.config.Learners,
+	}
+}
@@ -0,0 +1,80 @@
+package kvstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitForVoters(t *testing.T, node *testNode, ids ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		membership := node.store.Membership()
+		if membership.NewVoters == nil && len(membership.Voters) == len(ids) {
+			matched := true
+			for _, id := range ids {
+				matched = matched && hasPeer(membership.Voters, id)
+			}
+			if matched {
+				return
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("%s never moved to voters %v, it has %v", node.id, ids, node.store.Membership())
+}
+
+func TestMembershipChanges(t *testing.T) {
+	root := t.TempDir()
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	_, err := leader.store.Put("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a node that joins has no configuration of its own until the leader sends it one
+	joined := newRaftTestNode(t, root, "d")
+	err = joined.store.JoinRaft(joined.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = leader.store.AddMember(joined.peer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.store.AddMember(joined.peer()); !errors.Is(err, ErrMemberExists) {
+		t.Fatalf("adding a member twice gave %v", err)
+	}
+	nodes = append(nodes, joined)
+	for _, node := range nodes {
+		waitForVoters(t, node, "a", "b", "c", "d")
+	}
+	waitForValue(t, joined, "a", "1")
+
+	//removing the leader itself commits without it, and the rest elect a new one
+	err = leader.store.RemoveMember(leader.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	survivors := without(nodes, leader)
+	remaining := []string{}
+	for _, node := range survivors {
+		remaining = append(remaining, node.id)
+	}
+	for _, node := range survivors {
+		waitForVoters(t, node, remaining...)
+	}
+
+	next := waitForLeader(t, survivors)
+	_, err = next.store.Put("b", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range survivors {
+		waitForValue(t, node, "b", "2")
+	}
+	if err := next.store.RemoveMember(leader.id); !errors.Is(err, ErrUnknownMember) {
+		t.Fatalf("removing a node that already left gave %v", err)
+	}
+}
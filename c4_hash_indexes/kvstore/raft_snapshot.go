@@ -0,0 +1,286 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"keyvault/cluster"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const RaftInstallSnapshotPath = "/raft/install-snapshot"
+
+const (
+	raftSnapshotChunkSize = 256 * 1024
+	//followers further behind than this are caught up with a snapshot instead of holding back compaction
+	raftSnapshotLag = 1000
+)
+
+var ErrInvalidSnapshot = errors.New("snapshot chunk does not belong to a known segment")
+
+type installSnapshotRequest struct {
+	Term       uint64               `json:"term"`
+	LeaderId   string               `json:"leaderId"`
+	SnapshotId string               `json:"snapshotId"`
+	LastIndex  uint64               `json:"lastIndex"`
+	Segments   []walSegmentMetadata `json:"segments"`
+	Config     *raftConfig          `json:"config,omitempty"`
+	File       string               `json:"file"`
+	Offset     int64                `json:"offset"`
+	Data       []byte               `json:"data"`
+	Done       bool                 `json:"done"`
+}
+
+type installSnapshotResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+}
+
+func (raft *raftNode) snapshotDir(name string) string {
+	return filepath.Join(raft.store.wal.dir, name)
+}
+
+func (raft *raftNode) retainFrom() uint64 {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	return raft.lowWaterMark()
+}
+
+func (raft *raftNode) lowWaterMark() uint64 {
+	//only the leader knows where the followers are, everyone else compacts what it has applied
+	if raft.role != raftLeader {
+		return math.MaxUint64
+	}
+
+	lastIndex := raft.store.wal.lastIndex()
+	mark := lastIndex + 1
+	for _, peer := range raft.peers() {
+		matchIndex := raft.matchIndex[peer.Id]
+		if matchIndex+raftSnapshotLag < lastIndex {
+			continue
+		}
+		if matchIndex+1 < mark {
+			mark = matchIndex + 1
+		}
+	}
+	return mark
+}
+
+func (raft *raftNode) sendSnapshot(peer cluster.Peer) {
+	raft.mutex.Lock()
+	term := raft.state.CurrentTerm
+	config := raft.committedConfig()
+	raft.mutex.Unlock()
+
+	success := false
+	var lastIndex uint64 = 0
+	defer func() {
+		raft.mutex.Lock()
+		defer raft.mutex.Unlock()
+		raft.replicating[peer.Id] = false
+
+		if !success || raft.role != raftLeader || raft.state.CurrentTerm != term {
+			return
+		}
+		if lastIndex > raft.matchIndex[peer.Id] {
+			raft.matchIndex[peer.Id] = lastIndex
+		}
+		raft.nextIndex[peer.Id] = raft.matchIndex[peer.Id] + 1
+		raft.advanceCommitIndex()
+		go raft.replicateTo(peer)
+	}()
+
+	snapshotId := uuid.NewString()
+	dir := raft.snapshotDir("snapshot_" + snapshotId)
+	defer os.RemoveAll(dir)
+
+	metas, lastIndex, err := raft.store.wal.checkpoint(dir)
+	if err != nil {
+		log.Printf("raft: taking a snapshot for %s: %v", peer.Id, err)
+		return
+	}
+
+	request := installSnapshotRequest{
+		Term:       term,
+		LeaderId:   raft.selfId,
+		SnapshotId: snapshotId,
+		LastIndex:  lastIndex,
+		Segments:   metas,
+		Config:     &config,
+	}
+
+	send := func() bool {
+		var response installSnapshotResponse
+		err := raft.call(peer, RaftInstallSnapshotPath, request, &response)
+		if err != nil {
+			return false
+		}
+
+		if response.Term > term {
+			raft.mutex.Lock()
+			if response.Term > raft.state.CurrentTerm {
+				raft.becomeFollower(response.Term)
+			}
+			raft.mutex.Unlock()
+			return false
+		}
+		return response.Success
+	}
+
+	//each segment file goes over in chunks, the last request tells the follower to install
+	buffer := make([]byte, raftSnapshotChunkSize)
+	for _, meta := range metas {
+		file, err := os.Open(filepath.Join(dir, meta.segmentLogFileName()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("raft: reading snapshot for %s: %v", peer.Id, err)
+			return
+		}
+
+		request.File = meta.segmentLogFileName()
+		request.Offset = 0
+		for {
+			n, err := file.Read(buffer)
+			if n > 0 {
+				request.Data = buffer[:n]
+				if !send() {
+					file.Close()
+					return
+				}
+				request.Offset += int64(n)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				log.Printf("raft: reading snapshot for %s: %v", peer.Id, err)
+				return
+			}
+		}
+		file.Close()
+	}
+
+	request.File = ""
+	request.Offset = 0
+	request.Data = nil
+	request.Done = true
+	success = send()
+	if success {
+		log.Printf("raft: installed snapshot through %d on %s", lastIndex, peer.Id)
+	}
+}
+
+func (raft *raftNode) handleInstallSnapshot(request installSnapshotRequest) (installSnapshotResponse, error) {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if raft.stopped {
+		return installSnapshotResponse{}, ErrRaftStopped
+	}
+
+	if request.Term < raft.state.CurrentTerm {
+		return installSnapshotResponse{Term: raft.state.CurrentTerm}, nil
+	}
+
+	raft.becomeFollower(request.Term)
+	raft.leaderId = request.LeaderId
+	raft.lastLeaderContact = time.Now()
+	raft.resetElectionDeadline()
+
+	response := installSnapshotResponse{Term: raft.state.CurrentTerm, Success: true}
+
+	//everything in the snapshot is already committed here
+	if request.LastIndex <= raft.state.CommitIndex {
+		return response, nil
+	}
+
+	//a new snapshot id means the leader started over, chunks of the old one are useless
+	incomingDir := raft.snapshotDir("snapshot_incoming")
+	idPath := filepath.Join(incomingDir, "id")
+	id, _ := os.ReadFile(idPath)
+	if string(id) != request.SnapshotId {
+		os.RemoveAll(incomingDir)
+		err := os.MkdirAll(incomingDir, 0755)
+		if err != nil {
+			return response, err
+		}
+		err = os.WriteFile(idPath, []byte(request.SnapshotId), 0644)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	if !request.Done {
+		err := raft.writeSnapshotChunk(incomingDir, request)
+		return response, err
+	}
+
+	raft.store.sessions.reset()
+	err := raft.store.wal.installSnapshot(incomingDir, request.Segments, request.LastIndex)
+	if err != nil {
+		return response, err
+	}
+	os.RemoveAll(incomingDir)
+
+	raft.state.CommitIndex = request.LastIndex
+	if request.Config != nil {
+		raft.state.Config = request.Config
+	}
+	raft.pendingConfigs = nil
+	raft.config = raft.committedConfig()
+	raft.saveState()
+	raft.caughtUpAt = raft.lastLeaderContact
+
+	log.Printf("raft: installed snapshot through %d from %s", request.LastIndex, request.LeaderId)
+	return response, nil
+}
+
+func (raft *raftNode) writeSnapshotChunk(dir string, request installSnapshotRequest) error {
+	//the name comes from the leader, it must stay inside the snapshot directory
+	known := false
+	for _, meta := range request.Segments {
+		if meta.segmentLogFileName() == request.File {
+			known = true
+		}
+	}
+	if !known || filepath.Base(request.File) != request.File {
+		return ErrInvalidSnapshot
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, request.File), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteAt(request.Data, request.Offset)
+	return err
+}
+
+func (store *KvStore) HandleInstallSnapshot(body []byte) ([]byte, error) {
+	if store.raft == nil {
+		return nil, ErrRaftNotEnabled
+	}
+
+	var request installSnapshotRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := store.raft.handleInstallSnapshot(request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
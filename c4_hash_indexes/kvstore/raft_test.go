@@ -0,0 +1,305 @@
+package kvstore
+
+import (
+	"errors"
+	"io"
+	"keyvault/cluster"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	id     string
+	store  *KvStore
+	server *httptest.Server
+}
+
+func raftTestHandler(handle func(body []byte) ([]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		response, err := handle(body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		w.Write(response)
+	}
+}
+
+func newRaftTestNode(t *testing.T, root string, id string) *testNode {
+	node := &testNode{id: id}
+	node.store = NewKvStore(root + "/" + node.id)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(RaftRequestVotePath, raftTestHandler(node.store.HandleRequestVote))
+	mux.HandleFunc(RaftAppendEntriesPath, raftTestHandler(node.store.HandleAppendEntries))
+	mux.HandleFunc(RaftInstallSnapshotPath, raftTestHandler(node.store.HandleInstallSnapshot))
+	mux.HandleFunc(RaftTimeoutNowPath, raftTestHandler(node.store.HandleTimeoutNow))
+	node.server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		node.server.Close()
+		node.store.Close()
+	})
+	return node
+}
+
+func (node *testNode) peer() cluster.Peer {
+	return cluster.Peer{Id: node.id, Address: node.server.URL}
+}
+
+func startRaftCluster(t *testing.T, size int) []*testNode {
+	root := t.TempDir()
+
+	nodes := []*testNode{}
+	peers := []cluster.Peer{}
+	for i := 0; i < size; i++ {
+		node := newRaftTestNode(t, root, string(rune('a'+i)))
+		nodes = append(nodes, node)
+		peers = append(peers, node.peer())
+	}
+
+	for _, node := range nodes {
+		err := node.store.EnableRaft(node.id, peers)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodes
+}
+
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		leaders := []*testNode{}
+		for _, node := range nodes {
+			if node.store.IsLeader() {
+				leaders = append(leaders, node)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("no single leader was elected")
+	return nil
+}
+
+func waitForValue(t *testing.T, node *testNode, key string, value string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := node.store.Get(key); got != nil && *got == value {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("%s never applied %s=%s", node.id, key, value)
+}
+
+type unreachable struct{}
+
+func (unreachable) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("unreachable")
+}
+
+func isolate(node *testNode) {
+	//closing the server only stops what comes in, the node's own heartbeats have to be cut as well
+	node.server.CloseClientConnections()
+	node.server.Close()
+
+	node.store.raft.mutex.Lock()
+	node.store.raft.client = &http.Client{Transport: unreachable{}}
+	node.store.raft.mutex.Unlock()
+}
+
+func without(nodes []*testNode, gone *testNode) []*testNode {
+	rest := []*testNode{}
+	for _, node := range nodes {
+		if node != gone {
+			rest = append(rest, node)
+		}
+	}
+	return rest
+}
+
+func TestRaftElectsCommitsAndFailsOver(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+
+	leader := waitForLeader(t, nodes)
+	_, err := leader.store.Put("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		waitForValue(t, node, "a", "1")
+	}
+
+	//followers refuse writes and point at the leader
+	follower := without(nodes, leader)[0]
+	_, err = follower.store.Put("b", "1")
+	if err == nil {
+		t.Fatal("a follower took a write")
+	}
+
+	//with the leader cut off the other two elect one of themselves and keep committing
+	isolate(leader)
+	survivors := without(nodes, leader)
+
+	next := waitForLeader(t, survivors)
+	_, err = next.store.Put("b", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range survivors {
+		waitForValue(t, node, "a", "1")
+		waitForValue(t, node, "b", "2")
+	}
+}
+
+func TestCloseStopsRaft(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a")
+	store := NewKvStore(dir)
+	err := store.EnableRaft("a", []cluster.Peer{{Id: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForLeader(t, []*testNode{{id: "a", store: store}})
+
+	store.Close()
+	select {
+	case <-store.raft.done:
+	case <-time.After(time.Second):
+		t.Fatal("raft kept ticking after the store was closed")
+	}
+
+	//a vote asked of a closed node is refused without writing anything where the store was
+	os.RemoveAll(dir)
+	response, _ := store.HandleRequestVote([]byte(`{"term":100,"candidateId":"b","lastLogIndex":100,"lastLogTerm":100}`))
+	if strings.Contains(string(response), `"voteGranted":true`) {
+		t.Fatal("a closed node granted a vote")
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("a closed node wrote to its directory")
+	}
+}
+
+func TestTermRuns(t *testing.T) {
+	terms := newTermRuns()
+	for index, term := range []uint64{1, 1, 1, 2, 2, 3} {
+		terms.record(uint64(index+1), term)
+	}
+
+	expected := map[uint64]uint64{1: 1, 3: 1, 4: 2, 5: 2, 6: 3}
+	for index, term := range expected {
+		if got, _ := terms.at(index); got != term {
+			t.Fatalf("term at %d is %d, expected %d", index, got, term)
+		}
+	}
+	if _, found := terms.at(7); found {
+		t.Fatal("an index past the log has a term")
+	}
+
+	//a conflicting entry replaces the tail
+	terms.record(4, 4)
+	if got, _ := terms.at(4); got != 4 {
+		t.Fatalf("term at 4 is %d after the overwrite", got)
+	}
+	if _, found := terms.at(5); found {
+		t.Fatal("the truncated tail still has terms")
+	}
+}
+
+func TestNewerGenerationLeavesRaftAlone(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	term := leader.store.Generation()
+
+	err := leader.store.CheckGeneration(term + 5)
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("a newer generation gave %v", err)
+	}
+	if !leader.store.IsLeader() || leader.store.Generation() != term {
+		t.Fatal("a client header changed the leader's term or role")
+	}
+}
+
+func TestLeadershipTransfer(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	target := without(nodes, leader)[0]
+
+	err := leader.store.TransferLeadership(target.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := waitForLeader(t, nodes); next != target {
+		t.Fatalf("%s leads after a transfer to %s", next.id, target.id)
+	}
+}
+
+func TestTransferVoteOnlyFromTarget(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	_, err := leader.store.Put("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	followers := without(nodes, leader)
+	voter, candidate := followers[0], followers[1]
+	waitForValue(t, voter, "a", "1")
+
+	//no transfer was asked for, so claiming one must not get a vote while the leader is heard from
+	term := voter.store.Generation()
+	response := voter.store.raft.handleRequestVote(requestVoteRequest{
+		Term:               term + 1,
+		CandidateId:        candidate.id,
+		LastLogIndex:       voter.store.LastIndex(),
+		LastLogTerm:        term,
+		LeadershipTransfer: true,
+	})
+	if response.VoteGranted || voter.store.Generation() != term {
+		t.Fatal("a vote was given for a transfer the leader never started")
+	}
+}
+
+func TestLowWaterMarkFollowsTheSlowestFollower(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	_, err := leader.store.Put("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		waitForValue(t, node, "a", "1")
+	}
+	if mark := leader.store.raft.retainFrom(); mark != leader.store.LastIndex()+1 {
+		t.Fatalf("with every follower caught up the mark is %d, the log ends at %d", mark, leader.store.LastIndex())
+	}
+
+	//a follower that falls behind holds the mark at the first entry it is missing
+	slow := without(nodes, leader)[0]
+	isolate(slow)
+	acknowledged := slow.store.LastIndex()
+	for i := 0; i < 3; i++ {
+		_, err = leader.store.Put("b", strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mark := leader.store.raft.retainFrom(); mark != acknowledged+1 {
+		t.Fatalf("the mark is %d, the slow follower acknowledged %d", mark, acknowledged)
+	}
+
+	//followers compact what they have applied, only the leader holds back
+	if mark := slow.store.raft.retainFrom(); mark != math.MaxUint64 {
+		t.Fatalf("a follower's mark is %d", mark)
+	}
+}
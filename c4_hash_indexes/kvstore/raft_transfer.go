@@ -0,0 +1,142 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"keyvault/cluster"
+	"time"
+)
+
+const RaftTimeoutNowPath = "/raft/timeout-now"
+
+var ErrNotVoter = errors.New("node is not a voting member")
+var ErrTransferInProgress = errors.New("leadership is being transferred")
+var ErrTransferTimeout = errors.New("leadership transfer did not complete in time")
+
+type timeoutNowRequest struct {
+	Term     uint64 `json:"term"`
+	LeaderId string `json:"leaderId"`
+}
+
+type timeoutNowResponse struct {
+	Term uint64 `json:"term"`
+}
+
+func (store *KvStore) TransferLeadership(id string) error {
+	if store.raft == nil {
+		return ErrRaftNotEnabled
+	}
+	return store.raft.transferLeadership(id)
+}
+
+func (raft *raftNode) transferLeadership(id string) error {
+	raft.mutex.Lock()
+	if raft.role != raftLeader {
+		raft.mutex.Unlock()
+		return ErrNotLeader
+	}
+	if id == raft.selfId {
+		raft.mutex.Unlock()
+		return nil
+	}
+	if !raft.config.isVoter(id) {
+		raft.mutex.Unlock()
+		return ErrNotVoter
+	}
+	if raft.transferringTo != "" {
+		raft.mutex.Unlock()
+		return ErrTransferInProgress
+	}
+
+	//new writes are turned away so the target can catch up with a log that stays put
+	raft.transferringTo = id
+	transferStart := time.Now()
+	term := raft.state.CurrentTerm
+	var target cluster.Peer
+	for _, peer := range raft.peers() {
+		if peer.Id == id {
+			target = peer
+		}
+	}
+	raft.mutex.Unlock()
+
+	defer func() {
+		raft.mutex.Lock()
+		raft.transferringTo = ""
+		raft.mutex.Unlock()
+	}()
+
+	deadline := time.Now().Add(raftCommitTimeout)
+	for {
+		raft.mutex.Lock()
+		stillLeader := raft.role == raftLeader && raft.state.CurrentTerm == term
+		//a quorum has to know the target before it campaigns, or they would not vote for it
+		caughtUp := raft.matchIndex[id] >= raft.store.wal.lastIndex() && raft.confirmedSince(transferStart)
+		raft.mutex.Unlock()
+
+		if !stillLeader {
+			return ErrLeadershipLost
+		}
+		if caughtUp {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrTransferTimeout
+		}
+
+		raft.mutex.Lock()
+		raft.replicateToAll()
+		raft.mutex.Unlock()
+		time.Sleep(raftTickInterval)
+	}
+
+	//once the target is told to campaign it may win at any moment, so acks from before do not hold a lease
+	raft.mutex.Lock()
+	raft.leaseVoidedAt = time.Now()
+	raft.mutex.Unlock()
+
+	//the target campaigns right away instead of waiting for its election timeout
+	var response timeoutNowResponse
+	err := raft.call(target, RaftTimeoutNowPath, timeoutNowRequest{Term: term, LeaderId: raft.selfId}, &response)
+	if err != nil {
+		return err
+	}
+
+	for time.Now().Before(deadline) {
+		raft.mutex.Lock()
+		done := raft.role != raftLeader || raft.state.CurrentTerm != term
+		raft.mutex.Unlock()
+
+		if done {
+			return nil
+		}
+		time.Sleep(raftTickInterval)
+	}
+	return ErrTransferTimeout
+}
+
+func (raft *raftNode) handleTimeoutNow(request timeoutNowRequest) timeoutNowResponse {
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if raft.stopped || request.Term < raft.state.CurrentTerm || raft.role == raftLeader || !raft.config.isVoter(raft.selfId) {
+		return timeoutNowResponse{Term: raft.state.CurrentTerm}
+	}
+
+	raft.startElection(true)
+	return timeoutNowResponse{Term: raft.state.CurrentTerm}
+}
+
+func (store *KvStore) HandleTimeoutNow(body []byte) ([]byte, error) {
+	if store.raft == nil {
+		return nil, ErrRaftNotEnabled
+	}
+
+	var request timeoutNowRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(store.raft.handleTimeoutNow(request))
+}
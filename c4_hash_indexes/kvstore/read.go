@@ -0,0 +1,223 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Consistency string
+
+const (
+	ConsistencyStale        Consistency = "stale"
+	ConsistencyLinearizable Consistency = "linearizable"
+)
+
+var ErrUnknownConsistency = errors.New("unknown consistency, expected linearizable or stale")
+var ErrReadNotConfirmed = errors.New("leadership could not be confirmed by a majority in time")
+var ErrIndexNotReached = errors.New("this node has not applied the requested index yet")
+var ErrTimestampNotReached = errors.New("this node has not applied writes up to the requested timestamp yet")
+var ErrLagUnknown = errors.New("this node does not know how far behind the leader it is")
+
+func ParseConsistency(consistency string) (Consistency, error) {
+	switch Consistency(consistency) {
+	case "", ConsistencyStale:
+		return ConsistencyStale, nil
+	case ConsistencyLinearizable:
+		return ConsistencyLinearizable, nil
+	}
+	return "", ErrUnknownConsistency
+}
+
+func (store *KvStore) GetConsistent(key string, consistency Consistency) (*string, Timestamp, error) {
+	if consistency == ConsistencyLinearizable {
+		err := store.readBarrier()
+		if err != nil {
+			return nil, Timestamp{}, err
+		}
+	}
+
+	value, version := store.GetVersion(key)
+	return value, version, nil
+}
+
+func (store *KvStore) WaitForIndex(ctx context.Context, index uint64) error {
+	store.wal.mutex.RLock()
+	nextIndex := store.wal.visibleNextIndex()
+	store.wal.mutex.RUnlock()
+
+	if index < nextIndex {
+		return nil
+	}
+
+	//changes are published as they are applied, so the feed tells us when the index is reached
+	sub := store.Subscribe(nextIndex)
+	defer sub.Close()
+
+	for {
+		select {
+		case change, open := <-sub.Changes():
+			if !open {
+				return sub.Err()
+			}
+			if change.Index >= index {
+				return nil
+			}
+		case <-ctx.Done():
+			return ErrIndexNotReached
+		}
+	}
+}
+
+func (store *KvStore) WaitForTimestamp(ctx context.Context, timestamp Timestamp) error {
+	//a node that stamps the writes moves its clock past the timestamp, anything stamped before is then in the log
+	if store.IsLeader() {
+		_, err := nodeClock.Update(timestamp)
+		if err != nil {
+			return err
+		}
+		return store.WaitForIndex(ctx, store.wal.lastIndex())
+	}
+
+	store.wal.mutex.RLock()
+	nextIndex := store.wal.visibleNextIndex()
+	store.wal.mutex.RUnlock()
+
+	//a follower only knows the leader got that far once one of its writes at or past the timestamp arrives
+	if last := store.wal.entryAt(nextIndex - 1); last != nil && !timestamp.After(last.Hlc) {
+		return nil
+	}
+
+	sub := store.Subscribe(nextIndex)
+	defer sub.Close()
+
+	for {
+		select {
+		case change, open := <-sub.Changes():
+			if !open {
+				return sub.Err()
+			}
+			if !timestamp.After(change.Hlc) {
+				return nil
+			}
+		case <-ctx.Done():
+			return ErrTimestampNotReached
+		}
+	}
+}
+
+func (store *KvStore) ReplicationLag() (time.Duration, error) {
+	if store.raft == nil {
+		if !store.IsFollower() {
+			return 0, nil
+		}
+		caughtUpAt := store.followerCaughtUpAt()
+		if caughtUpAt.IsZero() {
+			return 0, ErrLagUnknown
+		}
+		return time.Since(caughtUpAt), nil
+	}
+
+	raft := store.raft
+	raft.mutex.Lock()
+	defer raft.mutex.Unlock()
+
+	if raft.role == raftLeader {
+		return 0, nil
+	}
+	if raft.caughtUpAt.IsZero() {
+		return 0, ErrLagUnknown
+	}
+	return time.Since(raft.caughtUpAt), nil
+}
+
+func (store *KvStore) readBarrier() error {
+	if store.raft != nil {
+		return store.raft.readBarrier()
+	}
+
+	//a follower tailing a leader can always be behind it
+	if store.IsFollower() {
+		return ErrReadOnlyFollower
+	}
+	return nil
+}
+
+func (raft *raftNode) acknowledged(id string, sentAt time.Time) {
+	if sentAt.After(raft.ackedAt[id]) {
+		raft.ackedAt[id] = sentAt
+	}
+
+	close(raft.acked)
+	raft.acked = make(chan struct{})
+}
+
+func (raft *raftNode) confirmedSince(since time.Time) bool {
+	return raft.config.hasQuorum(func(id string) bool {
+		return id == raft.selfId || !raft.ackedAt[id].Before(since)
+	})
+}
+
+func (raft *raftNode) readBarrier() error {
+	requestedAt := time.Now()
+	deadline := time.NewTimer(raftCommitTimeout)
+	defer deadline.Stop()
+
+	raft.mutex.Lock()
+	if raft.role != raftLeader {
+		raft.mutex.Unlock()
+		return ErrNotLeader
+	}
+
+	//nothing from earlier terms is known to be committed until this term's first entry is
+	readIndex := raft.state.CommitIndex
+	if raft.termStartIndex > readIndex {
+		readIndex = raft.termStartIndex
+	}
+
+	//while a quorum acked within the lease no other leader can have been elected,
+	//once it runs out a fresh heartbeat round has to be answered first
+	leaseStart := requestedAt.Add(-raftLeaseDuration)
+	//during a transfer the target can take over at any moment, only a round started after the request counts
+	if raft.transferringTo != "" {
+		leaseStart = requestedAt
+	}
+	if raft.leaseVoidedAt.After(leaseStart) {
+		leaseStart = raft.leaseVoidedAt
+	}
+	if !raft.confirmedSince(leaseStart) {
+		raft.replicateToAll()
+	}
+
+	for !raft.confirmedSince(leaseStart) {
+		acked := raft.acked
+		raft.mutex.Unlock()
+
+		select {
+		case <-acked:
+		case <-deadline.C:
+			return ErrReadNotConfirmed
+		}
+
+		raft.mutex.Lock()
+		if raft.role != raftLeader {
+			raft.mutex.Unlock()
+			return ErrLeadershipLost
+		}
+	}
+
+	if raft.state.CommitIndex >= readIndex {
+		raft.mutex.Unlock()
+		return nil
+	}
+
+	waiter := raft.waitFor(readIndex)
+	raft.mutex.Unlock()
+
+	select {
+	case err := <-waiter:
+		return err
+	case <-deadline.C:
+		return ErrReadNotConfirmed
+	}
+}
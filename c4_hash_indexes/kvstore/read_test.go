@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseConsistency(t *testing.T) {
+	for value, expected := range map[string]Consistency{"": ConsistencyStale, "stale": ConsistencyStale, "linearizable": ConsistencyLinearizable} {
+		consistency, err := ParseConsistency(value)
+		if err != nil || consistency != expected {
+			t.Fatalf("%q parsed as %q %v", value, consistency, err)
+		}
+	}
+	if _, err := ParseConsistency("strong"); !errors.Is(err, ErrUnknownConsistency) {
+		t.Fatalf("an unknown consistency gave %v", err)
+	}
+}
+
+func TestLinearizableReads(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	leader := waitForLeader(t, nodes)
+	_, err := leader.store.Put("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, _, err := leader.store.GetConsistent("a", ConsistencyLinearizable)
+	if err != nil || value == nil || *value != "1" {
+		t.Fatalf("the leader read %v %v", value, err)
+	}
+
+	//a follower serves stale reads only, the leader has to confirm a linearizable one
+	follower := without(nodes, leader)[0]
+	waitForValue(t, follower, "a", "1")
+	if _, _, err := follower.store.GetConsistent("a", ConsistencyLinearizable); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("a linearizable read on a follower gave %v", err)
+	}
+	if value, _, err := follower.store.GetConsistent("a", ConsistencyStale); err != nil || value == nil || *value != "1" {
+		t.Fatalf("a stale read on a follower gave %v %v", value, err)
+	}
+
+	//a leader cut off from the rest still answers stale reads, but once its lease is gone never a linearizable one
+	isolate(leader)
+	survivors := without(nodes, leader)
+	next := waitForLeader(t, survivors)
+	_, err = next.store.Put("a", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := leader.store.GetConsistent("a", ConsistencyStale); value == nil || *value != "1" {
+		t.Fatalf("the deposed leader's stale read gave %v", value)
+	}
+	value, _, err = leader.store.GetConsistent("a", ConsistencyLinearizable)
+	if !errors.Is(err, ErrReadNotConfirmed) && !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("the deposed leader read %v %v", value, err)
+	}
+
+	value, _, err = next.store.GetConsistent("a", ConsistencyLinearizable)
+	if err != nil || value == nil || *value != "2" {
+		t.Fatalf("the new leader read %v %v", value, err)
+	}
+}
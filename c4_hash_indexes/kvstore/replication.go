@@ -0,0 +1,219 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const replicationRetryInterval = 1 * time.Second
+
+const maxReplicatedEventSize = 16 * 1024 * 1024
+
+var ErrReadOnlyFollower = errors.New("this node is a read-only follower")
+
+func (change *Change) toWalEntry() walEntry {
+	return walEntry{
+		Index:     change.Index,
+		Term:      change.Term,
+		Data:      change.Data,
+		EntryType: change.EntryType,
+		ClientId:  change.ClientId,
+		Sequence:  change.Sequence,
+		Hlc:       change.Hlc,
+	}
+}
+
+func (store *KvStore) IsFollower() bool {
+	store.followMutex.Lock()
+	defer store.followMutex.Unlock()
+
+	return store.leaderUrl != ""
+}
+
+func (store *KvStore) LeaderUrl() string {
+	if store.raft != nil {
+		return store.raft.leaderAddress()
+	}
+
+	store.followMutex.Lock()
+	defer store.followMutex.Unlock()
+
+	return store.leaderUrl
+}
+
+func (store *KvStore) FollowLeader(leaderUrl string) {
+	store.followMutex.Lock()
+	defer store.followMutex.Unlock()
+
+	leaderUrl = strings.TrimSuffix(leaderUrl, "/")
+	store.leaderUrl = leaderUrl
+	store.caughtUpAt = time.Time{}
+
+	if last := store.wal.entryAt(store.wal.lastIndex()); last != nil {
+		store.replicatedTerm = last.Term
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	store.stopFollowing = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		store.tailLeader(ctx, leaderUrl)
+	}()
+}
+
+func (store *KvStore) StopFollowing() {
+	store.followMutex.Lock()
+	stop := store.stopFollowing
+	store.stopFollowing = nil
+	store.followMutex.Unlock()
+
+	//waited for outside the lock, the tail takes it to record when it caught up,
+	//and once it has returned nothing writes to the log after the store is closed
+	if stop != nil {
+		stop()
+	}
+
+	//the store keeps what it has replicated so far and starts taking writes itself
+	store.followMutex.Lock()
+	store.leaderUrl = ""
+	store.followMutex.Unlock()
+}
+
+func (store *KvStore) tailLeader(ctx context.Context, leaderUrl string) {
+	for ctx.Err() == nil {
+		err := store.pullFromLeader(ctx, leaderUrl)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("replication from %s: %v", leaderUrl, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(replicationRetryInterval):
+		}
+	}
+}
+
+func (store *KvStore) pullFromLeader(ctx context.Context, leaderUrl string) error {
+	store.wal.mutex.RLock()
+	fromIndex := store.wal.nextIndex()
+	store.wal.mutex.RUnlock()
+
+	//an empty log also asks for index 0, which leaders started before it was reserved may hold
+	if fromIndex == 1 && store.wal.entryAt(0) == nil {
+		fromIndex = 0
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/changes?from=%d", leaderUrl, fromIndex), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader answered %s", response.Status)
+	}
+
+	return readServerSentEvents(response.Body, func(data []byte) error {
+		var change Change
+		err := json.Unmarshal(data, &change)
+		if err != nil {
+			return err
+		}
+		return store.applyReplicated(change)
+	}, func(comment string) {
+		//the head is the leader's last index when it wrote the comment, holding it means nothing was outstanding
+		value, found := strings.CutPrefix(comment, "head ")
+		if !found {
+			return
+		}
+		head, err := strconv.ParseUint(value, 10, 64)
+		if err == nil && store.wal.lastIndex() >= head {
+			store.markCaughtUp(time.Now())
+		}
+	})
+}
+
+func (store *KvStore) markCaughtUp(at time.Time) {
+	store.followMutex.Lock()
+	defer store.followMutex.Unlock()
+
+	store.caughtUpAt = at
+}
+
+func (store *KvStore) followerCaughtUpAt() time.Time {
+	store.followMutex.Lock()
+	defer store.followMutex.Unlock()
+
+	return store.caughtUpAt
+}
+
+func (store *KvStore) applyReplicated(change Change) error {
+	//a leader from an older generation has been replaced and must not be followed any more
+	if change.Term < store.replicatedTerm {
+		return fmt.Errorf("%w: change %d is from generation %d, already at %d", ErrStaleGeneration, change.Index, change.Term, store.replicatedTerm)
+	}
+
+	entry := change.toWalEntry()
+	err := store.wal.WriteEntry(&entry, &change.Index)
+	if errors.Is(err, ErrEntryAlreadyWritten) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	store.replicatedTerm = entry.Term
+	store.watches.notify(entry.toChange())
+	return nil
+}
+
+func readServerSentEvents(reader io.Reader, handle func(data []byte) error, handleComment func(comment string)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxReplicatedEventSize)
+
+	data := []byte{}
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		//a blank line dispatches the event collected so far
+		if line == "" {
+			if len(data) > 0 {
+				err := handle(data)
+				if err != nil {
+					return err
+				}
+			}
+			data = []byte{}
+			continue
+		}
+
+		if comment, found := strings.CutPrefix(line, ":"); found {
+			handleComment(strings.TrimPrefix(comment, " "))
+			continue
+		}
+
+		if value, found := strings.CutPrefix(line, "data:"); found {
+			data = append(data, strings.TrimPrefix(value, " ")...)
+		}
+	}
+
+	return scanner.Err()
+}
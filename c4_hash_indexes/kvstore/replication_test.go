@@ -0,0 +1,210 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serveChanges(store *KvStore) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		from, _ := strconv.ParseUint(req.URL.Query().Get("from"), 10, 64)
+		sub := store.Subscribe(from)
+		defer sub.Close()
+
+		w.WriteHeader(http.StatusOK)
+		for {
+			select {
+			case change, open := <-sub.Changes():
+				if !open {
+					return
+				}
+				data, _ := json.Marshal(change)
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}))
+}
+
+func TestStopFollowingWaitsForTheTail(t *testing.T) {
+	leader := NewKvStore(t.TempDir())
+	defer leader.Close()
+	server := serveChanges(leader)
+	defer server.Close()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+				leader.Put(strconv.Itoa(i), "v")
+			}
+		}
+	}()
+
+	follower := NewKvStore(t.TempDir())
+	follower.FollowLeader(server.URL)
+	time.Sleep(100 * time.Millisecond)
+
+	//once it returns the tail is gone, nothing is written to the log any more
+	follower.Close()
+	last := follower.LastIndex()
+	time.Sleep(50 * time.Millisecond)
+	if follower.LastIndex() != last || follower.IsFollower() {
+		t.Fatal("the follower kept replicating after it was closed")
+	}
+	if last == 0 {
+		t.Fatal("the follower never replicated anything")
+	}
+}
+
+func waitForReplica(t *testing.T, follower *KvStore, index uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := follower.WaitForIndex(ctx, index)
+	if err != nil {
+		t.Fatalf("the follower never reached index %d, it is at %d", index, follower.LastIndex())
+	}
+}
+
+func TestFollowerTailsTheLeader(t *testing.T) {
+	leader := NewKvStore(t.TempDir())
+	defer leader.Close()
+	server := serveChanges(leader)
+	defer server.Close()
+	leader.Put("a", "1")
+	leader.Put("b", "2")
+	leader.Delete("a")
+
+	dir := t.TempDir()
+	follower := NewKvStore(dir)
+	follower.FollowLeader(server.URL)
+	waitForReplica(t, follower, leader.LastIndex())
+
+	//what was written before it followed and what is written while it follows both arrive
+	leader.Put("c", "3")
+	waitForReplica(t, follower, leader.LastIndex())
+	if follower.Get("a") != nil || *follower.Get("b") != "2" || *follower.Get("c") != "3" {
+		t.Fatal("the follower does not serve what the leader wrote")
+	}
+	for index := uint64(1); index <= leader.LastIndex(); index++ {
+		if follower.wal.entryAt(index).toChange().Key != leader.wal.entryAt(index).toChange().Key {
+			t.Fatalf("entry %d is not at the leader's index on the follower", index)
+		}
+	}
+	if _, err := follower.Put("d", "4"); !errors.Is(err, ErrReadOnlyFollower) {
+		t.Fatalf("a write on the follower gave %v", err)
+	}
+
+	//a restarted follower picks up from its own last index without writing anything twice
+	follower.Close()
+	leader.Put("d", "4")
+	follower = NewKvStore(dir)
+	defer follower.Close()
+	follower.FollowLeader(server.URL)
+	waitForReplica(t, follower, leader.LastIndex())
+	if follower.LastIndex() != leader.LastIndex() || *follower.Get("d") != "4" {
+		t.Fatalf("after a restart the follower is at %d, the leader at %d", follower.LastIndex(), leader.LastIndex())
+	}
+
+	//once it stops following it takes writes after what it replicated
+	follower.StopFollowing()
+	index, err := follower.Put("e", "5")
+	if err != nil || index != leader.LastIndex()+1 {
+		t.Fatalf("a write after following stopped went to %d, %v", index, err)
+	}
+}
+
+func replicatedSet(t *testing.T, index uint64, term uint64, key string) Change {
+	entry, err := (&SetValueCommand{Key: key, Value: "v"}).toWalEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Index = index
+	entry.Term = term
+	return entry.toChange()
+}
+
+func TestFollowerRefusesAnOlderGeneration(t *testing.T) {
+	dir := t.TempDir()
+	follower := NewKvStore(dir)
+
+	err := follower.applyReplicated(replicatedSet(t, 1, 2, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = follower.applyReplicated(replicatedSet(t, 2, 1, "b"))
+	if !errors.Is(err, ErrStaleGeneration) {
+		t.Fatalf("a change from a deposed leader gave %v", err)
+	}
+	if follower.Get("b") != nil {
+		t.Fatal("a change from a deposed leader was applied")
+	}
+	follower.Close()
+
+	//the generation is kept in the log, so a restarted follower still refuses the old leader
+	follower = NewKvStore(dir)
+	defer follower.Close()
+	follower.FollowLeader("http://localhost:1")
+	err = follower.applyReplicated(replicatedSet(t, 2, 1, "b"))
+	if !errors.Is(err, ErrStaleGeneration) {
+		t.Fatalf("after a restart a change from a deposed leader gave %v", err)
+	}
+}
+
+func TestReadsWaitForATimestamp(t *testing.T) {
+	leader := NewKvStore(t.TempDir())
+	defer leader.Close()
+	server := serveChanges(leader)
+	defer server.Close()
+
+	//the node stamping writes moves its clock past the timestamp, so later writes order after it
+	ahead := NodeClock().Now()
+	ahead.Wall += int64(time.Second)
+	err := leader.WaitForTimestamp(context.Background(), ahead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Put("a", "1")
+	if _, written := leader.GetVersion("a"); !written.After(ahead) {
+		t.Fatalf("a write at %s was stamped before the timestamp %s", written, ahead)
+	}
+
+	follower := NewKvStore(t.TempDir())
+	defer follower.Close()
+	follower.FollowLeader(server.URL)
+
+	//a follower waits until the leader's writes up to the timestamp have reached it
+	_, written := leader.GetVersion("a")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = follower.WaitForTimestamp(ctx, written)
+	if err != nil || follower.Get("a") == nil {
+		t.Fatalf("the follower waited for %s and gave %v", written, err)
+	}
+
+	later := NodeClock().Now()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = follower.WaitForTimestamp(ctx, later)
+	if !errors.Is(err, ErrTimestampNotReached) {
+		t.Fatalf("waiting for a time no write has reached gave %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package kvstore
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+const clientSessionTtl = 10 * time.Minute
+const clientSessionSweepInterval = 1 * time.Minute
+
+var ErrStaleSequence = errors.New("request sequence is older than the last one applied for this client")
+var ErrMissingSequence = errors.New("requests with a client id need a sequence above 0")
+
+type clientSession struct {
+	Sequence uint64 `json:"sequence"`
+	Index    uint64 `json:"index"`
+	//where the client's latest run of back to back entries starts, a batch is always one run
+	RunStart uint64 `json:"runStart,omitempty"`
+	LastSeen int64  `json:"lastSeen"`
+}
+
+type sessionTable struct {
+	sessions  map[string]*clientSession
+	now       int64
+	lastSweep int64
+	mutex     sync.Mutex
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		sessions: make(map[string]*clientSession),
+	}
+}
+
+func (table *sessionTable) reset() {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	table.sessions = make(map[string]*clientSession)
+	table.now = 0
+	table.lastSweep = 0
+}
+
+func (table *sessionTable) restore(sessions map[string]*clientSession) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	//the saved table is everything up to where it was taken, so it replaces what was rebuilt so far
+	table.sessions = make(map[string]*clientSession)
+	table.now = 0
+	for clientId, session := range sessions {
+		copied := *session
+		table.sessions[clientId] = &copied
+		table.now = max(table.now, session.LastSeen)
+	}
+	table.lastSweep = table.now
+}
+
+func (table *sessionTable) copy() map[string]*clientSession {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	sessions := make(map[string]*clientSession)
+	for clientId, session := range table.sessions {
+		copied := *session
+		sessions[clientId] = &copied
+	}
+	return sessions
+}
+
+func (table *sessionTable) expired(session *clientSession, now int64) bool {
+	return now-session.LastSeen > clientSessionTtl.Nanoseconds()
+}
+
+func (table *sessionTable) sweep() {
+	if table.now-table.lastSweep < clientSessionSweepInterval.Nanoseconds() {
+		return
+	}
+	table.lastSweep = table.now
+
+	maps.DeleteFunc(table.sessions, func(clientId string, session *clientSession) bool {
+		return table.expired(session, table.now)
+	})
+}
+
+func (table *sessionTable) admit(entry *walEntry) bool {
+	if entry.ClientId == "" {
+		return true
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	//time comes from the leader's stamp on the entry, so every replica expires sessions at the same point in the log
+	now := max(table.now, entry.Hlc.Wall)
+	table.now = now
+	table.sweep()
+
+	//every replica applies the log in the same order, so they all turn away the same retries
+	session, exists := table.sessions[entry.ClientId]
+	if exists && !table.expired(session, now) && entry.Sequence <= session.Sequence {
+		return false
+	}
+
+	runStart := entry.Index
+	if exists && !table.expired(session, now) && session.Index+1 == entry.Index {
+		runStart = session.RunStart
+	}
+	table.sessions[entry.ClientId] = &clientSession{
+		Sequence: entry.Sequence,
+		Index:    entry.Index,
+		RunStart: runStart,
+		LastSeen: now,
+	}
+	return true
+}
+
+func (table *sessionTable) lookup(clientId string, sequence uint64) (uint64, bool, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	session, exists := table.sessions[clientId]
+	if !exists || table.expired(session, table.now) || sequence > session.Sequence {
+		return 0, false, nil
+	}
+	if sequence < session.Sequence {
+		return 0, false, ErrStaleSequence
+	}
+	return session.Index, true, nil
+}
+
+func (table *sessionTable) lookupBatch(clientId string, sequence uint64, count int) ([]uint64, bool, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	session, exists := table.sessions[clientId]
+	if !exists || table.expired(session, table.now) || sequence > session.Sequence {
+		return nil, false, nil
+	}
+	if sequence < session.Sequence {
+		return nil, false, ErrStaleSequence
+	}
+
+	//the batch ended the run, so its operations took the last indexes of it in order
+	indexes := make([]uint64, count)
+	for i := range indexes {
+		back := uint64(count - 1 - i)
+		indexes[i] = session.Index
+		if session.Index >= session.RunStart+back {
+			indexes[i] = session.Index - back
+		}
+	}
+	return indexes, true, nil
+}
@@ -0,0 +1,146 @@
+package kvstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sessionEntry(clientId string, sequence uint64, index uint64, at time.Time) *walEntry {
+	return &walEntry{ClientId: clientId, Sequence: sequence, Index: index, Hlc: Timestamp{Wall: at.UnixNano()}}
+}
+
+func TestSessionsExpireByLogTime(t *testing.T) {
+	table := newSessionTable()
+	start := time.Unix(1000, 0)
+
+	if !table.admit(sessionEntry("a", 1, 1, start)) {
+		t.Fatal("a first request was turned away")
+	}
+	if table.admit(sessionEntry("a", 1, 2, start.Add(time.Minute))) {
+		t.Fatal("a retry inside the ttl was applied")
+	}
+
+	//lookups leave the session alone, only entries in the log move it along
+	for i := 0; i < 3; i++ {
+		index, duplicate, _ := table.lookup("a", 1)
+		if !duplicate || index != 1 {
+			t.Fatalf("lookup gave %d %v", index, duplicate)
+		}
+	}
+	if table.sessions["a"].LastSeen != start.UnixNano() {
+		t.Fatal("a lookup changed when the session was last seen")
+	}
+
+	//another client's write moves the log past the ttl, however long ago that was on this machine
+	table.admit(sessionEntry("b", 1, 3, start.Add(clientSessionTtl+time.Second)))
+	if _, duplicate, _ := table.lookup("a", 1); duplicate {
+		t.Fatal("an expired session still answers retries")
+	}
+	if !table.admit(sessionEntry("a", 1, 4, start.Add(clientSessionTtl+2*time.Second))) {
+		t.Fatal("a request after the session expired was turned away")
+	}
+}
+
+func compactClientWrites(t *testing.T, dir string, keepNothing bool) (uint64, uint64) {
+	store := NewKvStore(dir)
+	defer store.Close()
+
+	//the client's writes are all overwritten or deleted, so compaction keeps none of them
+	var sequence uint64 = 0
+	for i := 0; i < 3*walSegmentSize; i++ {
+		sequence++
+		_, err := store.PutIdempotent("client", sequence, "k", "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sequence++
+	deleted, err := store.DeleteIdempotent("client", sequence, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepNothing {
+		//writes deleted again, until the closed segments end on a delete and only the open one holds a value
+		for store.wal.nextIndex()%walSegmentSize != 1 || store.wal.nextIndex() < 4*walSegmentSize {
+			store.Put("other", "v")
+			store.Delete("other")
+		}
+		store.Put("other", "v")
+	} else {
+		for i := 0; i < walSegmentSize; i++ {
+			store.Put("other", "v")
+		}
+	}
+
+	//each pass folds one more segment into the compacted ones
+	for i := 0; i < 8; i++ {
+		store.wal.cleanSegments()
+	}
+	return sequence, deleted
+}
+
+func checkClientSession(t *testing.T, dir string, sequence uint64, deleted uint64) {
+	store := NewKvStore(dir)
+	defer store.Close()
+
+	index, err := store.DeleteIdempotent("client", sequence, "k")
+	if err != nil || index != deleted {
+		t.Fatalf("a retry after compaction gave %d %v, expected %d", index, err, deleted)
+	}
+	_, err = store.PutIdempotent("client", sequence-1, "k", "v")
+	if err != ErrStaleSequence {
+		t.Fatalf("an older sequence after compaction gave %v", err)
+	}
+}
+
+func TestSessionsSurviveCompaction(t *testing.T) {
+	dir := t.TempDir()
+	sequence, deleted := compactClientWrites(t, dir, false)
+	checkClientSession(t, dir, sequence, deleted)
+}
+
+func TestSessionsSurviveFsck(t *testing.T) {
+	dir := t.TempDir()
+	sequence, deleted := compactClientWrites(t, dir, true)
+
+	metadata, err := readMetadataFile(filepath.Join(dir, "meta", "wal_metadata.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	carriers := 0
+	for _, meta := range metadata.SortedSegmentsMetadata {
+		if meta.holdsNoEntries() {
+			carriers++
+		}
+	}
+	if carriers == 0 {
+		t.Fatal("no compaction kept only the sessions")
+	}
+
+	//the segment that only carries sessions has no file, which is not a problem
+	issues, err := Verify(dir)
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("a healthy store reported %v %v", issues, err)
+	}
+	_, err = Repair(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repaired, err := readMetadataFile(filepath.Join(dir, "meta", "wal_metadata.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, after := uint64(0), uint64(0)
+	for _, meta := range metadata.SortedSegmentsMetadata {
+		before = max(before, meta.CompactedThrough)
+	}
+	for _, meta := range repaired.SortedSegmentsMetadata {
+		after = max(after, meta.CompactedThrough)
+	}
+	if after != before {
+		t.Fatalf("repair moved the compaction marker from %d to %d", before, after)
+	}
+	checkClientSession(t, dir, sequence, deleted)
+}
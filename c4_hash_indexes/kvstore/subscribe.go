@@ -0,0 +1,206 @@
+package kvstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const subscriptionBufferSize = 256
+
+var ErrSubscriptionLagged = errors.New("subscriber fell too far behind the live feed")
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+type Change struct {
+	Index     uint64       `json:"index"`
+	Term      uint64       `json:"term,omitempty"`
+	EntryType WalEntryType `json:"entryType"`
+	Data      []byte       `json:"data"`
+	Key       string       `json:"key"`
+	Value     *string      `json:"value"`
+	ClientId  string       `json:"clientId,omitempty"`
+	Sequence  uint64       `json:"sequence,omitempty"`
+	Hlc       Timestamp    `json:"hlc"`
+}
+
+func (entry *walEntry) toChange() Change {
+	change := Change{
+		Index:     entry.Index,
+		Term:      entry.Term,
+		EntryType: entry.EntryType,
+		Data:      entry.Data,
+		ClientId:  entry.ClientId,
+		Sequence:  entry.Sequence,
+		Hlc:       entry.Hlc,
+	}
+
+	key, value := entry.keyValue()
+	if key != nil {
+		change.Key = *key
+	}
+	change.Value = value
+
+	return change
+}
+
+type Subscription struct {
+	fromIndex uint64
+	changes   chan Change
+	live      chan Change
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	store     *KvStore
+}
+
+func (sub *Subscription) Changes() <-chan Change {
+	return sub.changes
+}
+
+func (sub *Subscription) Err() error {
+	return sub.err
+}
+
+func (sub *Subscription) Close() {
+	sub.store.unsubscribe(sub, ErrSubscriptionClosed)
+}
+
+type changeFeed struct {
+	subscribers map[*Subscription]bool
+	mutex       sync.Mutex
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+func (feed *changeFeed) publish(entry walEntry) {
+	//this runs on the wal write path, so a slow subscriber is dropped instead of blocking writers
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	change := entry.toChange()
+	for sub := range feed.subscribers {
+		select {
+		case sub.live <- change:
+		default:
+			delete(feed.subscribers, sub)
+			sub.stop(ErrSubscriptionLagged)
+		}
+	}
+}
+
+func (sub *Subscription) stop(err error) {
+	sub.closeOnce.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+func (store *KvStore) unsubscribe(sub *Subscription, err error) {
+	store.feed.mutex.Lock()
+	delete(store.feed.subscribers, sub)
+	store.feed.mutex.Unlock()
+
+	sub.stop(err)
+}
+
+func (store *KvStore) Subscribe(fromIndex uint64) *Subscription {
+	sub := &Subscription{
+		fromIndex: fromIndex,
+		changes:   make(chan Change),
+		live:      make(chan Change, subscriptionBufferSize),
+		done:      make(chan struct{}),
+		store:     store,
+	}
+
+	//register for the live feed under the write lock so nothing written
+	//after catchUpTo is missed by both the catch-up and the live feed
+	store.wal.mutex.RLock()
+	store.feed.mutex.Lock()
+	store.feed.subscribers[sub] = true
+	store.feed.mutex.Unlock()
+	catchUpTo := store.wal.visibleNextIndex()
+	store.wal.mutex.RUnlock()
+
+	go sub.run(catchUpTo)
+	return sub
+}
+
+func (sub *Subscription) send(change Change) bool {
+	select {
+	case sub.changes <- change:
+		return true
+	case <-sub.done:
+		return false
+	}
+}
+
+func (sub *Subscription) run(catchUpTo uint64) {
+	defer close(sub.changes)
+
+	nextIndex := sub.fromIndex
+	if nextIndex < catchUpTo {
+		nextIndex = sub.store.catchUp(sub, catchUpTo)
+	}
+
+	for {
+		select {
+		case change := <-sub.live:
+			if change.Index < nextIndex {
+				continue
+			}
+			if !sub.send(change) {
+				return
+			}
+			nextIndex = change.Index + 1
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (store *KvStore) catchUp(sub *Subscription, catchUpTo uint64) uint64 {
+	//the segments are linked aside, so a slow subscriber never holds off compaction
+	dir := filepath.Join(store.wal.dir, "catchup_"+uuid.NewString())
+	defer os.RemoveAll(dir)
+
+	metas, err := store.wal.linkSegmentsFrom(dir, sub.fromIndex)
+	if err != nil {
+		store.unsubscribe(sub, err)
+		return catchUpTo
+	}
+
+	nextIndex := sub.fromIndex
+	for _, meta := range metas {
+		if meta.isWhollyBefore(nextIndex) {
+			continue
+		}
+
+		stopped := false
+		newWalSegment(dir, meta).processEntries(func(entry walEntry) {
+			if stopped || entry.Index < nextIndex || entry.Index >= catchUpTo {
+				return
+			}
+			if !sub.send(entry.toChange()) {
+				stopped = true
+				return
+			}
+			nextIndex = entry.Index + 1
+		})
+
+		if stopped {
+			break
+		}
+	}
+
+	if nextIndex < catchUpTo {
+		nextIndex = catchUpTo
+	}
+	return nextIndex
+}
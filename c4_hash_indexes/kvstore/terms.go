@@ -0,0 +1,58 @@
+package kvstore
+
+import "sort"
+
+type termRun struct {
+	firstIndex uint64
+	term       uint64
+}
+
+type termRuns struct {
+	runs []termRun
+	last uint64
+}
+
+func newTermRuns() *termRuns {
+	return &termRuns{runs: []termRun{}}
+}
+
+func (terms *termRuns) record(index uint64, term uint64) {
+	//an entry written over an old one replaces everything from it onwards
+	if len(terms.runs) > 0 && index <= terms.last {
+		terms.truncate(index)
+	}
+	if len(terms.runs) == 0 || terms.runs[len(terms.runs)-1].term != term {
+		terms.runs = append(terms.runs, termRun{firstIndex: index, term: term})
+	}
+	terms.last = index
+}
+
+func (terms *termRuns) truncate(index uint64) {
+	i := sort.Search(len(terms.runs), func(i int) bool {
+		return terms.runs[i].firstIndex >= index
+	})
+	terms.runs = terms.runs[:i]
+	if index > 0 && terms.last >= index {
+		terms.last = index - 1
+	}
+}
+
+func (terms *termRuns) at(index uint64) (uint64, bool) {
+	if len(terms.runs) == 0 || index > terms.last || index < terms.runs[0].firstIndex {
+		return 0, false
+	}
+
+	//a run covers every index from its first one up to where the next run starts
+	i := sort.Search(len(terms.runs), func(i int) bool {
+		return terms.runs[i].firstIndex > index
+	})
+	return terms.runs[i-1].term, true
+}
+
+func (wal *wal) termAt(index uint64) uint64 {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	term, _ := wal.terms.at(index)
+	return term
+}
@@ -3,6 +3,7 @@ package kvstore
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,15 +15,29 @@ import (
 
 type WalEntryType int
 
+var ErrEntryAlreadyWritten = errors.New("wal already has an entry at this index")
+
 const (
 	WalEntryTypeSetCommand = iota
 	WalEntryTypeDeleteCommand
+	WalEntryTypeNoOp
+	WalEntryTypeConfigChange
 )
 
 type walEntry struct {
 	Index     uint64       `json:"index"`
+	Term      uint64       `json:"term,omitempty"` //generation of the leader that wrote it, bumped on every leadership change
 	Data      []byte       `json:"data"`
 	EntryType WalEntryType `json:"entryType"`
+	ClientId  string       `json:"clientId,omitempty"`
+	Sequence  uint64       `json:"sequence,omitempty"`
+	Hlc       Timestamp    `json:"hlc"`
+}
+
+type pendingEntry struct {
+	entry   walEntry
+	segment *walSegment
+	offset  int64
 }
 
 func (entry *walEntry) keyValue() (*string, *string) {
@@ -52,22 +67,35 @@ func (meta *walMetadata) sortMetadata() {
 }
 
 type wal struct {
+	dir                  string
 	sortedSegments       []*walSegment
 	openSegment          *walSegment
 	metatada             *walMetadata
 	segmentCleanupTicker *time.Ticker
 	cleaningSegments     bool
 	segmentCleanupMutex  sync.Mutex
+	mutex                sync.RWMutex
+	afterWrite           func(entry walEntry)
+	deferIndexing        bool
+	pending              []pendingEntry
+	appliedIndex         uint64
+	admit                func(entry *walEntry) bool
+	restoreSessions      func(sessions map[string]*clientSession)
+	skipped              map[uint64]bool
+	retainFrom           func() uint64
+	ordered              *orderedKeys
+	merkle               *merkleIndex
+	terms                *termRuns
 }
 
-func newWal() *wal {
-	wal := &wal{}
+func newWal(dir string) *wal {
+	wal := &wal{dir: dir, skipped: make(map[uint64]bool), ordered: newOrderedKeys(), merkle: newMerkleIndex(nil), terms: newTermRuns()}
 	wal.startCleanupTicker()
 	return wal
 }
 
 func (wal *wal) metaPath() string {
-	return filepath.Join("dat", "meta", "wal_metadata.dat")
+	return filepath.Join(wal.dir, "meta", "wal_metadata.dat")
 }
 
 func (wal *wal) newMetadata(segmentIndex uint64, firstEntryIndex uint64) *walSegmentMetadata {
@@ -88,13 +116,14 @@ func (wal *wal) openNewSegment(index uint64, previousSegment *walSegment) *walSe
 		wal.openSegment.close()
 	}
 
-	var firstEntryIndex uint64 = 0
+	//index 0 is never written so that it can stand for an empty log
+	var firstEntryIndex uint64 = 1
 	if previousSegment != nil {
 		firstEntryIndex = previousSegment.meta.LastEntryIndex
 	}
 
 	meta := wal.newMetadata(index, firstEntryIndex)
-	segment := newWalSegment(meta)
+	segment := newWalSegment(wal.dir, meta)
 
 	wal.sortedSegments = append(wal.sortedSegments, segment)
 	wal.metatada.SortedSegmentsMetadata = append(wal.metatada.SortedSegmentsMetadata, meta)
@@ -110,17 +139,22 @@ func (wal *wal) deleteSegment(segmentId string) {
 	for i, m := range wal.metatada.SortedSegmentsMetadata {
 		if m.Id == segmentId {
 			segmentMetadataIndex = i
-			segmentToDelete = newWalSegment(m)
+			segmentToDelete = newWalSegment(wal.dir, m)
 			break
 		}
 	}
 
+	//the segment may already be gone if an earlier cleanup was interrupted
+	if segmentToDelete == nil {
+		return
+	}
+
 	//remove it from the metadata
 	sortedSegments := wal.metatada.SortedSegmentsMetadata
 	wal.metatada.SortedSegmentsMetadata = append(sortedSegments[:segmentMetadataIndex], sortedSegments[segmentMetadataIndex+1:]...)
 
 	//delete the segment file
-	segmentToDelete.meta.deleteSegmentLogFile()
+	segmentToDelete.meta.deleteSegmentLogFile(wal.dir)
 
 	//save the updated metadata without the meta of the deleted segment
 	wal.metatada.sortMetadata()
@@ -128,6 +162,9 @@ func (wal *wal) deleteSegment(segmentId string) {
 }
 
 func (wal *wal) getNextDirtySegment() *walSegmentMetadata {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
 	wal.loadMetadata()
 	var value *walSegmentMetadata
 
@@ -148,7 +185,7 @@ func (wal *wal) saveMetadata() {
 		panic(err)
 	}
 
-	os.MkdirAll("dat/meta", 0755)
+	os.MkdirAll(filepath.Join(wal.dir, "meta"), 0755)
 	os.WriteFile(wal.metaPath(), data, 0644)
 }
 
@@ -181,7 +218,7 @@ func (wal *wal) readSegments() {
 	var segments []*walSegment = []*walSegment{}
 
 	for _, meta := range wal.metatada.SortedSegmentsMetadata {
-		segments = append(segments, newWalSegment(meta))
+		segments = append(segments, newWalSegment(wal.dir, meta))
 	}
 
 	if len(segments) > 0 {
@@ -198,17 +235,426 @@ func (wal *wal) maybeRoll() {
 	}
 }
 
-func (wal *wal) WriteEntry(entry *walEntry) error {
+func (wal *wal) WriteEntry(entry *walEntry, index *uint64) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	//an entry copied from another log keeps its index, anything already covered is skipped
+	if index != nil && *index < wal.nextIndex() {
+		return ErrEntryAlreadyWritten
+	}
+	//entries copied from another log keep the time they were written there
+	if index == nil {
+		entry.Hlc = nodeClock.Now()
+	}
+
+	return wal.appendEntry(entry, index)
+}
+
+func (wal *wal) WriteEntries(entries []*walEntry) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	//metadata is saved once for the whole batch instead of after every entry
+	defer wal.saveMetadata()
+
+	for _, entry := range entries {
+		//an entry that already carries a time keeps it, like one copied through WriteEntry
+		if entry.Hlc.IsZero() {
+			entry.Hlc = nodeClock.Now()
+		}
+		wal.maybeRoll()
+		offset, err := wal.openSegment.writeEntry(entry, nil)
+		if err != nil {
+			return err
+		}
+		wal.entryWritten(entry, offset)
+	}
+
+	return nil
+}
+
+func (wal *wal) appendEntry(entry *walEntry, index *uint64) error {
 	wal.maybeRoll()
-	err := wal.openSegment.writeEntry(entry, nil)
+	offset, err := wal.openSegment.writeEntry(entry, index)
 
 	if err == nil {
+		if index != nil {
+			wal.openSegment.meta.LastEntryIndex = *index + 1
+		}
 		wal.saveMetadata()
+		wal.entryWritten(entry, offset)
 	}
 	return err
 }
 
+func (wal *wal) entryWritten(entry *walEntry, offset int64) {
+	wal.terms.record(entry.Index, entry.Term)
+
+	//entries that still have to be committed stay invisible to readers until they are applied
+	if wal.deferIndexing {
+		wal.pending = append(wal.pending, pendingEntry{entry: *entry, segment: wal.openSegment, offset: offset})
+		return
+	}
+
+	if wal.apply(wal.openSegment, entry, offset) {
+		wal.notifyWrite(entry)
+	}
+}
+
+func (wal *wal) apply(segment *walSegment, entry *walEntry, offset int64) bool {
+	//entries the store turns away, like retried requests, stay in the log but never become visible
+	if wal.admit != nil && !wal.admit(entry) {
+		wal.skipped[entry.Index] = true
+		return false
+	}
+
+	wal.observe(entry)
+	segment.indexEntry(entry, offset)
+	wal.indexKey(entry)
+	wal.merkleKey(entry)
+	return true
+}
+
+func (wal *wal) applyThrough(index uint64) []walEntry {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	applied := []walEntry{}
+	count := 0
+	for _, pending := range wal.pending {
+		if pending.entry.Index > index {
+			break
+		}
+		if wal.apply(pending.segment, &pending.entry, pending.offset) {
+			wal.notifyWrite(&pending.entry)
+			applied = append(applied, pending.entry)
+		}
+		count++
+	}
+	wal.pending = wal.pending[count:]
+
+	if index > wal.appliedIndex {
+		wal.appliedIndex = index
+	}
+	return applied
+}
+
+func (wal *wal) notifyWrite(entry *walEntry) {
+	if wal.afterWrite != nil {
+		wal.afterWrite(*entry)
+	}
+}
+
+func (wal *wal) nextIndex() uint64 {
+	return wal.openSegment.meta.LastEntryIndex
+}
+
+func (wal *wal) visibleNextIndex() uint64 {
+	if wal.deferIndexing {
+		return wal.appliedIndex + 1
+	}
+	return wal.nextIndex()
+}
+
+func (wal *wal) lastIndex() uint64 {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	//logs written before index 0 was reserved can be empty at 0
+	if wal.nextIndex() == 0 {
+		return 0
+	}
+	return wal.nextIndex() - 1
+}
+
+func (wal *wal) segmentsFrom(index uint64) []*walSegment {
+	segments := []*walSegment{}
+	for _, segment := range wal.sortedSegments {
+		if !segment.meta.isWhollyBefore(index) {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func (wal *wal) entryAt(index uint64) *walEntry {
+	entries := wal.entriesFrom(index, 1)
+	if len(entries) == 0 || entries[0].Index != index {
+		return nil
+	}
+	return &entries[0]
+}
+
+func (wal *wal) entriesFrom(index uint64, max int) []walEntry {
+	wal.mutex.RLock()
+	segments := wal.segmentsFrom(index)
+	wal.mutex.RUnlock()
+
+	entries := []walEntry{}
+	for _, segment := range segments {
+		segment.processEntries(func(entry walEntry) {
+			if entry.Index >= index && len(entries) < max {
+				entries = append(entries, entry)
+			}
+		})
+
+		if len(entries) >= max {
+			break
+		}
+	}
+
+	return entries
+}
+
+func (wal *wal) truncateFrom(index uint64) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if index >= wal.nextIndex() {
+		return nil
+	}
+
+	//drop whole segments from the tail, then cut the segment the index falls in
+	for len(wal.sortedSegments) > 0 {
+		segment := wal.sortedSegments[len(wal.sortedSegments)-1]
+		if segment.meta.IsCompactedSegment || segment.meta.FirstEntryIndex < index {
+			break
+		}
+
+		segment.close()
+		segment.meta.deleteSegmentLogFile(wal.dir)
+		wal.sortedSegments = wal.sortedSegments[:len(wal.sortedSegments)-1]
+		wal.removeSegmentMetadata(segment.meta.Id)
+	}
+
+	var previous *walSegment
+	if len(wal.sortedSegments) > 0 {
+		previous = wal.sortedSegments[len(wal.sortedSegments)-1]
+	}
+
+	if previous == nil || previous.meta.IsCompactedSegment {
+		var segmentIndex uint64 = 0
+		if previous != nil {
+			segmentIndex = previous.meta.SegmentIndex + 1
+		}
+
+		wal.openSegment = nil
+		segment := wal.openNewSegment(segmentIndex, nil)
+		segment.meta.FirstEntryIndex = index
+		segment.meta.LastEntryIndex = index
+	} else {
+		offset, found := previous.offsetOf(index)
+		if found {
+			err := previous.truncateFrom(offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		if previous.meta.LastEntryIndex > index {
+			previous.meta.LastEntryIndex = index
+		}
+		previous.meta.Closed = false
+		wal.openSegment = previous
+	}
+
+	wal.terms.truncate(index)
+
+	pending := []pendingEntry{}
+	for _, entry := range wal.pending {
+		if entry.entry.Index < index {
+			pending = append(pending, entry)
+		}
+	}
+	wal.pending = pending
+
+	wal.saveMetadata()
+	return nil
+}
+
+func (wal *wal) compactedThrough() uint64 {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	var index uint64 = 0
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		if meta.IsCompactedSegment && meta.CompactionCompleted && meta.lastIncludedIndex() > index {
+			index = meta.lastIncludedIndex()
+		}
+	}
+	return index
+}
+
+func (wal *wal) linkSegmentsFrom(dir string, index uint64) ([]*walSegmentMetadata, error) {
+	wal.segmentCleanupMutex.Lock()
+	defer wal.segmentCleanupMutex.Unlock()
+
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	//the open segment is linked too, entries appended to it later show up through the link
+	metas := []*walSegmentMetadata{}
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		if (meta.IsCompactedSegment && !meta.CompactionCompleted) || meta.isWhollyBefore(index) {
+			continue
+		}
+
+		err = os.Link(meta.segmentLogFilePath(wal.dir), filepath.Join(dir, meta.segmentLogFileName()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		copied := *meta
+		metas = append(metas, &copied)
+	}
+	return metas, nil
+}
+
+func (wal *wal) checkpoint(snapshotDir string) ([]walSegmentMetadata, uint64, error) {
+	//compaction is held off while the files are linked so none disappear halfway
+	wal.segmentCleanupMutex.Lock()
+	defer wal.segmentCleanupMutex.Unlock()
+
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
+	err := os.MkdirAll(snapshotDir, 0755)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//closed segments are never written again, linking them gives a consistent view
+	metas := []walSegmentMetadata{}
+	var lastIndex uint64 = 0
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		if meta.IsCompactedSegment && !meta.CompactionCompleted {
+			continue
+		}
+		if !meta.Closed || !meta.isWhollyThrough(wal.appliedIndex) {
+			break
+		}
+
+		err = os.Link(meta.segmentLogFilePath(wal.dir), filepath.Join(snapshotDir, meta.segmentLogFileName()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, 0, err
+		}
+
+		metas = append(metas, *meta)
+		if meta.lastIncludedIndex() > lastIndex {
+			lastIndex = meta.lastIncludedIndex()
+		}
+	}
+
+	return metas, lastIndex, nil
+}
+
+func (wal *wal) installSnapshot(snapshotDir string, metas []walSegmentMetadata, lastIndex uint64) error {
+	wal.segmentCleanupMutex.Lock()
+	defer wal.segmentCleanupMutex.Unlock()
+
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	//the whole local log is replaced by the snapshot
+	if wal.openSegment != nil {
+		wal.openSegment.close()
+	}
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		meta.deleteSegmentLogFile(wal.dir)
+	}
+
+	wal.metatada = &walMetadata{SortedSegmentsMetadata: []*walSegmentMetadata{}}
+	wal.sortedSegments = []*walSegment{}
+
+	var nextSegmentIndex uint64 = 0
+	for i := range metas {
+		meta := &metas[i]
+		err := os.Rename(filepath.Join(snapshotDir, meta.segmentLogFileName()), meta.segmentLogFilePath(wal.dir))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		wal.metatada.SortedSegmentsMetadata = append(wal.metatada.SortedSegmentsMetadata, meta)
+		wal.sortedSegments = append(wal.sortedSegments, newWalSegment(wal.dir, meta))
+		if meta.SegmentIndex >= nextSegmentIndex {
+			nextSegmentIndex = meta.SegmentIndex + 1
+		}
+	}
+
+	wal.openSegment = nil
+	segment := wal.openNewSegment(nextSegmentIndex, nil)
+	segment.meta.FirstEntryIndex = lastIndex + 1
+	segment.meta.LastEntryIndex = lastIndex + 1
+	wal.saveMetadata()
+
+	wal.appliedIndex = lastIndex
+	return wal.reindex(&lastIndex)
+}
+
+func (wal *wal) removeSegmentMetadata(segmentId string) {
+	metas := []*walSegmentMetadata{}
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		if meta.Id != segmentId {
+			metas = append(metas, meta)
+		}
+	}
+	wal.metatada.SortedSegmentsMetadata = metas
+}
+
+func (wal *wal) deferIndexingAfter(index uint64) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	wal.deferIndexing = true
+	wal.appliedIndex = index
+
+	//reindex so entries past the index wait to be applied
+	return wal.reindex(&index)
+}
+
+func (wal *wal) reindex(limit *uint64) error {
+	wal.pending = []pendingEntry{}
+	wal.skipped = make(map[uint64]bool)
+	wal.ordered = newOrderedKeys()
+	wal.merkle = newMerkleIndex(wal.merkle.scopes)
+	wal.terms = newTermRuns()
+
+	for _, segment := range wal.sortedSegments {
+		if segment.meta.IsCompactedSegment && !segment.meta.CompactionCompleted {
+			continue
+		}
+
+		segment.hashIndex = make(map[string]int64)
+		pending, err := segment.loadHashIndex(limit, func(entry *walEntry, offset int64) {
+			wal.terms.record(entry.Index, entry.Term)
+			wal.apply(segment, entry, offset)
+		})
+		if err != nil {
+			return err
+		}
+		if segment.meta.Sessions != nil && wal.restoreSessions != nil {
+			wal.restoreSessions(segment.meta.Sessions)
+		}
+
+		for _, entry := range pending {
+			wal.terms.record(entry.entry.Index, entry.entry.Term)
+			entry.segment = segment
+			wal.pending = append(wal.pending, entry)
+		}
+	}
+
+	return nil
+}
+
 func (wal *wal) GetEntry(key string) *walEntry {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
+
 	count := len(wal.sortedSegments)
 	for i := count - 1; i >= 0; i-- {
 		segment := wal.sortedSegments[i]
@@ -228,23 +674,67 @@ func (wal *wal) GetEntry(key string) *walEntry {
 	return nil
 }
 
-func (wal *wal) loadHashIndex() error {
-	wal.readSegments()
+type entryLocation struct {
+	segment *walSegment
+	offset  int64
+}
 
-	for _, segment := range wal.sortedSegments {
-		if segment.meta.IsCompactedSegment && !segment.meta.CompactionCompleted {
-			continue
-		}
+func (wal *wal) latestEntryLocations(match func(key string) bool) []entryLocation {
+	wal.mutex.RLock()
+	defer wal.mutex.RUnlock()
 
-		err := segment.loadHashIndex()
-		if err != nil {
-			panic(err)
+	//newer segments shadow older ones, so walk them from the newest
+	locations := []entryLocation{}
+	seen := make(map[string]bool)
+	for i := len(wal.sortedSegments) - 1; i >= 0; i-- {
+		segment := wal.sortedSegments[i]
+		for key, offset := range segment.hashIndex {
+			if seen[key] || !match(key) {
+				continue
+			}
+			seen[key] = true
+			locations = append(locations, entryLocation{segment: segment, offset: offset})
 		}
 	}
 
+	return locations
+}
+
+func (wal *wal) loadHashIndex() error {
+	//links left behind by catch-ups a crash interrupted are of no use any more
+	leftovers, _ := filepath.Glob(filepath.Join(wal.dir, "catchup_*"))
+	for _, dir := range leftovers {
+		os.RemoveAll(dir)
+	}
+
+	wal.readSegments()
+
+	err := wal.reindex(nil)
+	if err != nil {
+		panic(err)
+	}
+
 	return nil
 }
 
+func (wal *wal) close() {
+	wal.segmentCleanupMutex.Lock()
+	defer wal.segmentCleanupMutex.Unlock()
+
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.segmentCleanupTicker != nil {
+		wal.segmentCleanupTicker.Stop()
+	}
+	if wal.openSegment != nil && wal.openSegment.fileWriter != nil {
+		wal.openSegment.fileWriter.Flush()
+		wal.openSegment.file.Close()
+		wal.openSegment.file = nil
+		wal.openSegment.fileWriter = nil
+	}
+}
+
 func (wal *wal) startCleanupTicker() {
 	ticker := time.NewTicker(1 * time.Minute)
 
@@ -265,6 +755,17 @@ func (wal *wal) cleanSegments() {
 	if wal.cleaningSegments {
 		return
 	}
+
+	//asked before taking the cleanup lock, the replicas' positions live behind other locks
+	wal.mutex.RLock()
+	lowWaterMark := wal.retainFrom
+	wal.mutex.RUnlock()
+
+	var retainFrom uint64 = math.MaxUint64
+	if lowWaterMark != nil {
+		retainFrom = lowWaterMark()
+	}
+
 	wal.segmentCleanupMutex.Lock()
 	wal.cleaningSegments = true
 
@@ -273,15 +774,38 @@ func (wal *wal) cleanSegments() {
 
 	offsetMap := make(map[string]*uint64)
 	cleanedSegments := []*walSegment{}
-	segmentToClean := newWalSegment(wal.getNextDirtySegment())
+	segmentToClean := newWalSegment(wal.dir, wal.getNextDirtySegment())
 
 	if segmentToClean == nil || !segmentToClean.meta.Closed {
 		return
 	}
 
+	//never compact entries that have not been committed yet, nor the last entry since its term is still needed
+	if wal.deferIndexing {
+		wal.mutex.RLock()
+		keep := !segmentToClean.meta.isWhollyThrough(wal.appliedIndex) || segmentToClean.meta.LastEntryIndex >= wal.nextIndex()
+		wal.mutex.RUnlock()
+		if keep {
+			return
+		}
+	}
+
+	//entries some replica has not acknowledged yet stay in the log
+	if !segmentToClean.meta.isWhollyBefore(retainFrom) {
+		return
+	}
+
+	//entries that were turned away when applied must not come back through compaction
+	wal.mutex.RLock()
+	skipped := make(map[uint64]bool)
+	for index := range wal.skipped {
+		skipped[index] = true
+	}
+	wal.mutex.RUnlock()
+
 	segmentToClean.processEntries(func(entry walEntry) {
 		key, _ := entry.keyValue()
-		if key == nil {
+		if key == nil || skipped[entry.Index] {
 			return
 		}
 
@@ -292,14 +816,18 @@ func (wal *wal) cleanSegments() {
 	})
 
 	entries := []walEntry{}
+	sessions := newSessionTable()
 
 	for _, meta := range wal.metatada.SortedSegmentsMetadata {
-		segment := newWalSegment(meta)
+		segment := newWalSegment(wal.dir, meta)
 
 		if segmentToClean.meta.SegmentIndex >= segment.meta.SegmentIndex && segment.meta.Closed {
+			//sessions are replayed from every entry, including the ones compaction drops
 			segment.processEntries(func(entry walEntry) {
+				sessions.admit(&entry)
+
 				key, value := entry.keyValue()
-				if key == nil {
+				if key == nil || skipped[entry.Index] {
 					return
 				}
 
@@ -322,6 +850,9 @@ func (wal *wal) cleanSegments() {
 				}
 			})
 
+			if meta.Sessions != nil {
+				sessions.restore(meta.Sessions)
+			}
 			cleanedSegments = append(cleanedSegments, segment)
 		}
 
@@ -338,13 +869,31 @@ func (wal *wal) cleanSegments() {
 	var currentSegment *walSegment
 	var currentSegmentMeta *walSegmentMetadata
 
+	cleanedThrough := segmentToClean.meta.lastIncludedIndex()
+
+	//a compaction that keeps no entries still leaves a segment behind to carry the sessions
+	if len(entries) == 0 {
+		meta := wal.newMetadata(0, cleanedThrough+1)
+		meta.LastEntryIndex = cleanedThrough
+		meta.IsCompactedSegment = true
+		meta.CompactedThrough = cleanedThrough
+		meta.Sessions = sessions.copy()
+		newSegmentMetas = append(newSegmentMetas, meta)
+
+		wal.mutex.Lock()
+		wal.metatada.SortedSegmentsMetadata = append(wal.metatada.SortedSegmentsMetadata, meta)
+		wal.saveMetadata()
+		wal.mutex.Unlock()
+	}
+
 	for index, entry := range entries {
 		if currentBatchSize == 0 {
 			currentSegmentMeta = wal.newMetadata(uint64(len(newSegmentMetas)), entry.Index)
 			currentSegmentMeta.IsCompactedSegment = true
 			currentSegmentMeta.CompactionCompleted = false
+			currentSegmentMeta.CompactedThrough = cleanedThrough
 			currentSegmentMeta.FirstEntryIndex = entry.Index
-			currentSegment = newWalSegment(currentSegmentMeta)
+			currentSegment = newWalSegment(wal.dir, currentSegmentMeta)
 			newSegmentMetas = append(newSegmentMetas, currentSegmentMeta)
 		}
 
@@ -353,14 +902,22 @@ func (wal *wal) cleanSegments() {
 
 		if currentBatchSize >= batchSize || index == len(entries)-1 {
 			currentSegmentMeta.LastEntryIndex = entry.Index
+			if index == len(entries)-1 {
+				currentSegmentMeta.Sessions = sessions.copy()
+			}
 
+			wal.mutex.Lock()
 			wal.metatada.SortedSegmentsMetadata = append(wal.metatada.SortedSegmentsMetadata, currentSegmentMeta)
 			wal.saveMetadata()
+			wal.mutex.Unlock()
 
 			currentBatchSize = 0
 		}
 	}
 
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
 	for _, meta := range newSegmentMetas {
 		meta.CompactionCompleted = true
 		meta.Closed = true
@@ -371,4 +928,30 @@ func (wal *wal) cleanSegments() {
 		wal.deleteSegment(segment.meta.Id)
 	}
 	wal.saveMetadata()
+
+	wal.refreshSegments()
+}
+
+func (wal *wal) refreshSegments() {
+	//segments that survived keep their index, compacted ones only hold applied entries so they are indexed as they are
+	existing := make(map[string]*walSegment)
+	for _, segment := range wal.sortedSegments {
+		existing[segment.meta.Id] = segment
+	}
+
+	segments := []*walSegment{}
+	for _, meta := range wal.metatada.SortedSegmentsMetadata {
+		segment, exists := existing[meta.Id]
+		if !exists {
+			segment = newWalSegment(wal.dir, meta)
+			if meta.IsCompactedSegment && meta.CompactionCompleted {
+				segment.loadHashIndex(nil, func(entry *walEntry, offset int64) {
+					segment.indexEntry(entry, offset)
+				})
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	wal.sortedSegments = segments
 }
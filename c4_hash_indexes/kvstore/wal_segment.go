@@ -23,9 +23,14 @@ type walSegmentMetadata struct {
 	Id                  string    `json:"id"`
 	IsCompactedSegment  bool      `json:"isCompactedSegment"`
 	CompactionCompleted bool      `json:"compactionCompleted"`
+	//compaction drops deletes, no-ops and config entries, so the range it cleaned can reach past the last entry kept
+	CompactedThrough uint64 `json:"compactedThrough,omitempty"`
+	//the last compacted segment keeps the client sessions of every entry it replaced
+	Sessions map[string]*clientSession `json:"sessions,omitempty"`
 }
 
 type walSegment struct {
+	dir        string
 	meta       *walSegmentMetadata
 	file       *os.File
 	fileWriter *bufio.Writer
@@ -33,12 +38,13 @@ type walSegment struct {
 	hashIndex  map[string]int64
 }
 
-func newWalSegment(meta *walSegmentMetadata) *walSegment {
+func newWalSegment(dir string, meta *walSegmentMetadata) *walSegment {
 	if meta == nil {
 		return nil
 	}
 
 	segment := walSegment{
+		dir:       dir,
 		meta:      meta,
 		hashIndex: make(map[string]int64),
 	}
@@ -46,12 +52,29 @@ func newWalSegment(meta *walSegmentMetadata) *walSegment {
 	return &segment
 }
 
-func (meta *walSegmentMetadata) segmentLogFilePath() string {
-	return filepath.Join("dat", fmt.Sprintf("wal_segment_%d_%s.wal", meta.SegmentIndex, meta.Id))
+func (meta *walSegmentMetadata) segmentLogFilePath(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("wal_segment_%d_%s.wal", meta.SegmentIndex, meta.Id))
 }
 
-func (meta *walSegmentMetadata) deleteSegmentLogFile() error {
-	return os.Remove(meta.segmentLogFilePath())
+func (meta *walSegmentMetadata) segmentLogFileName() string {
+	return filepath.Base(meta.segmentLogFilePath(""))
+}
+
+func (meta *walSegmentMetadata) lastIncludedIndex() uint64 {
+	//compacted segments record the index of their last entry, live segments the next free index
+	if meta.IsCompactedSegment {
+		return max(meta.LastEntryIndex, meta.CompactedThrough)
+	}
+	return meta.LastEntryIndex - 1
+}
+
+func (meta *walSegmentMetadata) holdsNoEntries() bool {
+	//a compaction that kept nothing leaves a segment without a file, only to carry the sessions
+	return meta.IsCompactedSegment && meta.LastEntryIndex < meta.FirstEntryIndex
+}
+
+func (meta *walSegmentMetadata) deleteSegmentLogFile(dir string) error {
+	return os.Remove(meta.segmentLogFilePath(dir))
 }
 
 func (meta *walSegmentMetadata) isAtCapacity() bool {
@@ -61,11 +84,28 @@ func (meta *walSegmentMetadata) isAtCapacity() bool {
 	return (meta.LastEntryIndex - meta.FirstEntryIndex) >= walSegmentSize
 }
 
+func (meta *walSegmentMetadata) isWhollyThrough(index uint64) bool {
+	//compacted segments record the index of their last entry, live segments the next free index
+	if meta.IsCompactedSegment {
+		return meta.lastIncludedIndex() <= index
+	}
+	return meta.LastEntryIndex <= index+1
+}
+
+func (meta *walSegmentMetadata) isWhollyBefore(index uint64) bool {
+	//compacted segments record the index of their last entry, live segments the next free index
+	if meta.IsCompactedSegment {
+		return meta.lastIncludedIndex() < index
+	}
+	return meta.LastEntryIndex <= index
+}
+
 func (walSegment *walSegment) ReadEntryAtOffset(offset int64) *walEntry {
-	file, err := os.Open(walSegment.meta.segmentLogFilePath())
+	file, err := os.Open(walSegment.meta.segmentLogFilePath(walSegment.dir))
 	if err != nil {
 		return nil
 	}
+	defer file.Close()
 
 	file.Seek(offset, io.SeekStart)
 	reader := bufio.NewReader(file)
@@ -83,12 +123,12 @@ func (walSegment *walSegment) ReadEntryAtOffset(offset int64) *walEntry {
 	return entry
 }
 
-func (walSegment *walSegment) writeEntry(entry *walEntry, index *uint64) error {
+func (walSegment *walSegment) writeEntry(entry *walEntry, index *uint64) (int64, error) {
 	if walSegment.meta.Closed {
 		panic("cannot write to a closed segment")
 	}
 
-	if walSegment.meta.segmentLogFilePath() == "" {
+	if walSegment.meta.segmentLogFilePath(walSegment.dir) == "" {
 		panic("invalid walSegment filename")
 	}
 
@@ -100,7 +140,7 @@ func (walSegment *walSegment) writeEntry(entry *walEntry, index *uint64) error {
 	defer walSegment.writeMutex.Unlock()
 
 	if walSegment.file == nil || walSegment.fileWriter == nil {
-		file, err := os.OpenFile(walSegment.meta.segmentLogFilePath(), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		file, err := os.OpenFile(walSegment.meta.segmentLogFilePath(walSegment.dir), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
 		if err != nil {
 			panic(err)
 		}
@@ -133,24 +173,32 @@ func (walSegment *walSegment) writeEntry(entry *walEntry, index *uint64) error {
 		}
 	}
 
-	//update the hash index with the offset of the new entry
-	k, _ := entry.keyValue()
-	walSegment.hashIndex[*k] = offset
+	return offset, err
+}
 
-	return err
+func (walSegment *walSegment) indexEntry(entry *walEntry, offset int64) {
+	//entries that carry no key, like raft no-ops, are never looked up
+	key, _ := entry.keyValue()
+	if key == nil {
+		return
+	}
+	walSegment.hashIndex[*key] = offset
 }
 
-func (walSegment *walSegment) loadHashIndex() error {
-	os.Mkdir("dat", 0755)
-	file, err := os.Open(walSegment.meta.segmentLogFilePath())
+func (walSegment *walSegment) loadHashIndex(limit *uint64, apply func(entry *walEntry, offset int64)) ([]pendingEntry, error) {
+	pending := []pendingEntry{}
+
+	os.MkdirAll(walSegment.dir, 0755)
+	file, err := os.Open(walSegment.meta.segmentLogFilePath(walSegment.dir))
 
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return pending, nil
 		}
 
-		return err
+		return pending, err
 	}
+	defer file.Close()
 
 	reader := bufio.NewReader(file)
 	diskOffeset := int64(0)
@@ -167,24 +215,33 @@ func (walSegment *walSegment) loadHashIndex() error {
 		var entry walEntry
 		json.Unmarshal(bytes, &entry)
 
-		key, _ := entry.keyValue()
-
-		walSegment.hashIndex[*key] = diskOffeset
+		if limit == nil || entry.Index <= *limit {
+			apply(&entry, diskOffeset)
+		} else {
+			pending = append(pending, pendingEntry{entry: entry, offset: diskOffeset})
+		}
 		diskOffeset += int64(len(bytes))
 	}
 
-	return nil
+	return pending, nil
 }
 
 type EntryOperation func(entry walEntry)
 
 func (walSegment *walSegment) processEntries(operation EntryOperation) {
-	os.Mkdir("dat", 0755)
-	file, err := os.Open(walSegment.meta.segmentLogFilePath())
+	walSegment.processEntriesWithSize(func(entry walEntry, size int64) {
+		operation(entry)
+	})
+}
+
+func (walSegment *walSegment) processEntriesWithSize(operation func(entry walEntry, size int64)) {
+	os.MkdirAll(walSegment.dir, 0755)
+	file, err := os.Open(walSegment.meta.segmentLogFilePath(walSegment.dir))
 
 	if err != nil {
 		return
 	}
+	defer file.Close()
 
 	reader := bufio.NewReader(file)
 
@@ -199,8 +256,42 @@ func (walSegment *walSegment) processEntries(operation EntryOperation) {
 
 		var entry walEntry
 		json.Unmarshal(bytes, &entry)
-		operation(entry)
+		operation(entry, int64(len(bytes)))
+	}
+}
+
+func (walSegment *walSegment) offsetOf(index uint64) (int64, bool) {
+	var found *int64
+	offset := int64(0)
+
+	walSegment.processEntriesWithSize(func(entry walEntry, size int64) {
+		if found == nil && entry.Index >= index {
+			value := offset
+			found = &value
+		}
+		offset += size
+	})
+
+	if found == nil {
+		return offset, false
 	}
+	return *found, true
+}
+
+func (walSegment *walSegment) truncateFrom(offset int64) error {
+	//the next write reopens the file and appends after the cut
+	if walSegment.fileWriter != nil {
+		walSegment.fileWriter.Flush()
+		walSegment.file.Close()
+	}
+	walSegment.file = nil
+	walSegment.fileWriter = nil
+
+	err := os.Truncate(walSegment.meta.segmentLogFilePath(walSegment.dir), offset)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
 }
 
 func (walSegment *walSegment) close() {
@@ -0,0 +1,82 @@
+package kvstore
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCompactionCoversDroppedEntries(t *testing.T) {
+	store := NewKvStore(t.TempDir())
+	defer store.Close()
+
+	//the first segment ends in deletes, which compaction drops
+	store.Put("a", "1")
+	store.Put("b", "1")
+	store.Put("c", "1")
+	store.Delete("a")
+	last, _ := store.Delete("b")
+	for i := 0; i < walSegmentSize; i++ {
+		store.Put("x", "1")
+	}
+
+	store.wal.cleanSegments()
+
+	if got := store.wal.compactedThrough(); got != last {
+		t.Fatalf("compacted through %d, the cleaned segment ended at %d", got, last)
+	}
+	if value := store.Get("c"); value == nil || *value != "1" {
+		t.Fatal("a kept entry was lost")
+	}
+	if store.Get("a") != nil || store.Get("b") != nil {
+		t.Fatal("a deleted key came back")
+	}
+}
+
+func TestWriteEntriesKeepsTheirTime(t *testing.T) {
+	store := NewKvStore(t.TempDir())
+	defer store.Close()
+
+	stamped := Timestamp{Wall: 42, Logical: 7}
+	first, _ := (&SetValueCommand{Key: "a", Value: "1"}).toWalEntry()
+	first.Hlc = stamped
+	second, _ := (&SetValueCommand{Key: "b", Value: "1"}).toWalEntry()
+	err := store.wal.WriteEntries([]*walEntry{&first, &second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, hlc := store.GetVersion("a"); hlc != stamped {
+		t.Fatalf("an entry written at %s was restamped as %s", stamped, hlc)
+	}
+	if _, hlc := store.GetVersion("b"); hlc.IsZero() {
+		t.Fatal("an entry without a time was not stamped")
+	}
+}
+
+func TestCompactionStopsAtTheLowWaterMark(t *testing.T) {
+	store := NewKvStore(t.TempDir())
+	defer store.Close()
+
+	for i := 0; i < 2*walSegmentSize+1; i++ {
+		store.Put("a", strconv.Itoa(i))
+	}
+
+	//a replica that has only acknowledged part of the first segment keeps all of it
+	mark := uint64(walSegmentSize)
+	store.wal.retainFrom = func() uint64 {
+		return mark
+	}
+	store.wal.cleanSegments()
+	if got := store.wal.compactedThrough(); got != 0 {
+		t.Fatalf("compacted through %d with the low-water mark at %d", got, mark)
+	}
+
+	mark = walSegmentSize + 1
+	store.wal.cleanSegments()
+	if got := store.wal.compactedThrough(); got != walSegmentSize {
+		t.Fatalf("compacted through %d with the low-water mark at %d", got, mark)
+	}
+	if value := store.Get("a"); value == nil || *value != strconv.Itoa(2*walSegmentSize) {
+		t.Fatalf("after compaction the key is %v", value)
+	}
+}
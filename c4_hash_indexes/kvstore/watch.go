@@ -0,0 +1,136 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrWatchTargetMissing = errors.New("watch needs a key or a prefix")
+
+type watcher struct {
+	key       string
+	prefix    string
+	fromIndex uint64
+	fired     chan Change
+}
+
+func (watcher *watcher) matches(change Change) bool {
+	if change.Index < watcher.fromIndex {
+		return false
+	}
+	if watcher.key != "" {
+		return change.Key == watcher.key
+	}
+	return strings.HasPrefix(change.Key, watcher.prefix)
+}
+
+type watchRegistry struct {
+	watchers map[*watcher]bool
+	mutex    sync.Mutex
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{
+		watchers: make(map[*watcher]bool),
+	}
+}
+
+func (registry *watchRegistry) add(watcher *watcher) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.watchers[watcher] = true
+}
+
+func (registry *watchRegistry) remove(watcher *watcher) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	delete(registry.watchers, watcher)
+}
+
+func (registry *watchRegistry) notify(change Change) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for watcher := range registry.watchers {
+		if !watcher.matches(change) {
+			continue
+		}
+
+		//each watcher is woken once, by the first matching change
+		delete(registry.watchers, watcher)
+		watcher.fired <- change
+	}
+}
+
+func (store *KvStore) Watch(ctx context.Context, key string, prefix string, since *uint64) (*Change, error) {
+	if key == "" && prefix == "" {
+		return nil, ErrWatchTargetMissing
+	}
+
+	watcher := &watcher{
+		key:    key,
+		prefix: prefix,
+		fired:  make(chan Change, 1),
+	}
+
+	//without a since index only changes written from now on are of interest
+	store.wal.mutex.RLock()
+	if since != nil {
+		watcher.fromIndex = *since + 1
+	} else {
+		watcher.fromIndex = store.wal.visibleNextIndex()
+	}
+	store.watches.add(watcher)
+	store.wal.mutex.RUnlock()
+	defer store.watches.remove(watcher)
+
+	//a matching change may already have been written after since
+	if existing := store.earliestMatchingChange(watcher); existing != nil {
+		return existing, nil
+	}
+
+	select {
+	case change := <-watcher.fired:
+		return &change, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (store *KvStore) earliestMatchingChange(watcher *watcher) *Change {
+	if watcher.key != "" {
+		entry := store.wal.GetEntry(watcher.key)
+		if entry == nil {
+			return nil
+		}
+
+		change := entry.toChange()
+		if !watcher.matches(change) {
+			return nil
+		}
+		return &change
+	}
+
+	locations := store.wal.latestEntryLocations(func(key string) bool {
+		return strings.HasPrefix(key, watcher.prefix)
+	})
+
+	var earliest *Change
+	for _, location := range locations {
+		entry := location.segment.ReadEntryAtOffset(location.offset)
+		if entry == nil {
+			continue
+		}
+
+		change := entry.toChange()
+		if watcher.matches(change) && (earliest == nil || change.Index < earliest.Index) {
+			earliest = &change
+		}
+	}
+
+	return earliest
+}
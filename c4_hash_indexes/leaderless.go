@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"keyvault/kvstore"
+	"keyvault/leaderless"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var replicas *leaderless.Node
+
+func handleLeaderlessError(w http.ResponseWriter, e error) {
+	if errors.Is(e, leaderless.ErrQuorumNotReached) {
+		retryLater(w, e.Error())
+		return
+	}
+	if errors.Is(e, leaderless.ErrInvalidQuorum) || errors.Is(e, kvstore.ErrClockOffset) {
+		handleHttpError(w, e)
+		return
+	}
+	http.Error(w, e.Error(), 500)
+}
+
+func quorumFromQuery(req *http.Request, name string) (int, error) {
+	//each request may ask for its own quorum, zero keeps the configured one
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func leaderlessHandler(w http.ResponseWriter, req *http.Request) {
+	method := req.Method
+
+	if method == http.MethodGet {
+		key := req.URL.Query().Get("key")
+		quorum, err := quorumFromQuery(req, "r")
+		if key == "" || err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		record, err := replicas.Get(key, quorum)
+		if err != nil {
+			handleLeaderlessError(w, err)
+			return
+		}
+
+		value := record.Value
+		if record.Deleted {
+			value = ""
+		}
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"key":     key,
+			"value":   value,
+			"version": record.Version,
+		})
+		return
+	}
+
+	var version leaderless.Version
+	if method == http.MethodPost {
+		var request PutRequest
+		err := json.NewDecoder(req.Body).Decode(&request)
+		if err != nil || !request.isValid() {
+			handleHttpError(w, err)
+			return
+		}
+		quorum, err := quorumFromQuery(req, "w")
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		version, err = replicas.Put(request.Key, request.Value, quorum)
+		if err != nil {
+			handleLeaderlessError(w, err)
+			return
+		}
+	} else if method == http.MethodDelete {
+		key := req.URL.Query().Get("key")
+		quorum, err := quorumFromQuery(req, "w")
+		if key == "" || err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		version, err = replicas.Delete(key, quorum)
+		if err != nil {
+			handleLeaderlessError(w, err)
+			return
+		}
+	} else {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"version": version,
+	})
+}
+
+func replicaHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		query := req.URL.Query()
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(replicas.Read(query.Get("key"), query.Get("for")))
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var write leaderless.ReplicaWrite
+	err := json.Unmarshal(body, &write)
+	if err != nil || write.Record.Key == "" {
+		handleHttpError(w, err)
+		return
+	}
+
+	err = replicas.Apply(write)
+	if err != nil {
+		handleLeaderlessError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"applied": true})
+}
+
+func hintsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replicas.Hints())
+}
+
+func merkleHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	query := req.URL.Query()
+	level, err := strconv.Atoi(query.Get("level"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+	positions := []int{}
+	for _, value := range strings.Split(query.Get("positions"), ",") {
+		position, err := strconv.Atoi(value)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+		positions = append(positions, position)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replicas.Merkle(query.Get("peer"), level, positions))
+}
+
+func merkleBucketHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	query := req.URL.Query()
+	bucket, err := strconv.Atoi(query.Get("bucket"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replicas.Bucket(query.Get("peer"), bucket))
+}
@@ -0,0 +1,167 @@
+package leaderless
+
+import (
+	"fmt"
+	"keyvault/kvstore"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MerklePath       = "/leaderless/merkle"
+	MerkleBucketPath = "/leaderless/merkle/bucket"
+)
+
+const antiEntropyInterval = 10 * time.Second
+
+type MerkleHashes struct {
+	Hashes []uint64 `json:"hashes"`
+}
+
+func (node *Node) sharedWith(key string) []string {
+	//a key is compared with exactly the nodes that should hold it too
+	list := node.ring.preferenceList(key)
+	list = list[:min(node.config.Replicas, len(list))]
+	if !slices.Contains(list, node.selfId) {
+		return nil
+	}
+
+	others := []string{}
+	for _, id := range list {
+		if id != node.selfId {
+			others = append(others, id)
+		}
+	}
+	return others
+}
+
+func (node *Node) antiEntropy() {
+	defer node.running.Done()
+
+	ticker := time.NewTicker(antiEntropyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for id := range node.peers {
+				if !node.alive(id) {
+					continue
+				}
+				err := node.syncWith(id)
+				if err != nil {
+					log.Printf("leaderless: anti-entropy with %s: %v", id, err)
+				}
+			}
+		case <-node.stop:
+			return
+		}
+	}
+}
+
+func (node *Node) syncWith(id string) error {
+	tree := node.replica.data.MerkleTree(id)
+
+	//walk down from the root, only the children of hashes that differ are asked for
+	positions := []int{0}
+	for level := 0; level < len(tree.Levels) && len(positions) > 0; level++ {
+		remote, err := node.remoteHashes(id, level, positions)
+		if err != nil {
+			return err
+		}
+		local := tree.Hashes(level, positions)
+		if len(remote) != len(local) {
+			return fmt.Errorf("%s answered %d hashes for %d positions", id, len(remote), len(positions))
+		}
+
+		differing := []int{}
+		for i, position := range positions {
+			if local[i] != remote[i] {
+				differing = append(differing, position)
+			}
+		}
+		if level == len(tree.Levels)-1 {
+			return node.syncBuckets(id, differing)
+		}
+
+		positions = []int{}
+		for _, position := range differing {
+			positions = append(positions, 2*position, 2*position+1)
+		}
+	}
+	return nil
+}
+
+func (node *Node) syncBuckets(id string, buckets []int) error {
+	pulled, pushed := 0, 0
+	for _, bucket := range buckets {
+		var remote []Record
+		query := url.Values{"peer": {node.selfId}, "bucket": {strconv.Itoa(bucket)}}
+		err := node.call(id, http.MethodGet, MerkleBucketPath+"?"+query.Encode(), nil, &remote)
+		if err != nil {
+			return err
+		}
+
+		local := make(map[string]Record)
+		for _, record := range node.replica.bucket(bucket, id) {
+			local[record.Key] = record
+		}
+
+		//each side ends up with the newer of the two versions of every key
+		for _, record := range remote {
+			current, exists := local[record.Key]
+			delete(local, record.Key)
+			if exists && !record.Version.After(current.Version) {
+				if current.Version.After(record.Version) {
+					err = node.writeTo(target{node: id}, current)
+					pushed++
+				}
+			} else {
+				err = node.replica.apply(record)
+				pulled++
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, record := range local {
+			err = node.writeTo(target{node: id}, record)
+			if err != nil {
+				return err
+			}
+			pushed++
+		}
+	}
+
+	if pulled > 0 || pushed > 0 {
+		log.Printf("leaderless: anti-entropy with %s compared %d buckets, pulled %d and pushed %d keys", id, len(buckets), pulled, pushed)
+	}
+	return nil
+}
+
+func (node *Node) remoteHashes(id string, level int, positions []int) ([]uint64, error) {
+	list := []string{}
+	for _, position := range positions {
+		list = append(list, strconv.Itoa(position))
+	}
+
+	query := url.Values{"peer": {node.selfId}, "level": {strconv.Itoa(level)}, "positions": {strings.Join(list, ",")}}
+	var response MerkleHashes
+	err := node.call(id, http.MethodGet, MerklePath+"?"+query.Encode(), nil, &response)
+	return response.Hashes, err
+}
+
+func (node *Node) Merkle(peer string, level int, positions []int) MerkleHashes {
+	return MerkleHashes{Hashes: node.replica.data.MerkleTree(peer).Hashes(level, positions)}
+}
+
+func (node *Node) Bucket(peer string, bucket int) []Record {
+	if bucket < 0 || bucket >= kvstore.MerkleBuckets {
+		return []Record{}
+	}
+	return node.replica.bucket(bucket, peer)
+}
@@ -0,0 +1,58 @@
+package leaderless
+
+import (
+	"keyvault/kvstore"
+	"testing"
+)
+
+func TestSyncConvergesDivergedReplicas(t *testing.T) {
+	c := startTestCluster(t, []string{"n1", "n2"}, Config{Replicas: 2, ReadQuorum: 1, WriteQuorum: 1})
+	n1, n2 := c.nodes["n1"], c.nodes["n2"]
+
+	version := func(node string) Version {
+		return Version{Timestamp: kvstore.NodeClock().Now(), Node: node}
+	}
+	both := Record{Key: "both", Value: "same", Version: version("n1")}
+	older := Record{Key: "newer", Value: "old", Version: version("n1")}
+	newer := Record{Key: "newer", Value: "new", Version: version("n2")}
+	live := Record{Key: "deleted", Value: "v", Version: version("n1")}
+	deleted := Record{Key: "deleted", Deleted: true, Version: version("n2")}
+
+	//each replica missed writes the other one applied
+	for _, write := range []struct {
+		node   *Node
+		record Record
+	}{
+		{n1, both}, {n2, both},
+		{n1, Record{Key: "only1", Value: "v", Version: version("n1")}},
+		{n2, Record{Key: "only2", Value: "v", Version: version("n2")}},
+		{n1, older}, {n2, newer},
+		{n1, live}, {n2, deleted},
+	} {
+		err := write.node.Apply(ReplicaWrite{Record: write.record})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n1.replica.data.MerkleTree("n2").Root() == n2.replica.data.MerkleTree("n1").Root() {
+		t.Fatal("the replicas did not diverge")
+	}
+
+	err := n1.syncWith("n2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Record{"only1": {Value: "v"}, "only2": {Value: "v"}, "both": both, "newer": newer, "deleted": deleted}
+	for _, node := range []*Node{n1, n2} {
+		for key, record := range expected {
+			got := node.Read(key, "")
+			if got.Value != record.Value || got.Deleted != record.Deleted {
+				t.Fatalf("%s holds %s as %v, expected %v", node.selfId, key, got, record)
+			}
+		}
+	}
+	if n1.replica.data.MerkleTree("n2").Root() != n2.replica.data.MerkleTree("n1").Root() {
+		t.Fatal("the trees still differ after the sync")
+	}
+}
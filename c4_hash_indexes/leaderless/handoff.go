@@ -0,0 +1,74 @@
+package leaderless
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const handoffInterval = 5 * time.Second
+
+func (node *Node) handOffHints() {
+	defer node.running.Done()
+
+	ticker := time.NewTicker(handoffInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for id := range node.peers {
+				if node.alive(id) {
+					node.handOffTo(id)
+				}
+			}
+		case <-node.stop:
+			return
+		}
+	}
+}
+
+func (node *Node) handOffTo(id string) {
+	//hints go back oldest key first, the first failure leaves the rest for the next round
+	delivered := 0
+	for _, record := range node.replica.hintsFor(id) {
+		err := node.writeTo(target{node: id}, record)
+		if err != nil {
+			log.Printf("leaderless: handing off to %s: %v", id, err)
+			break
+		}
+		err = node.replica.dropHint(id, record)
+		if err != nil {
+			log.Printf("leaderless: dropping hint for %s: %v", id, err)
+			break
+		}
+		delivered++
+	}
+	if delivered > 0 {
+		log.Printf("leaderless: handed off %d writes to %s", delivered, id)
+	}
+}
+
+type HintStatus struct {
+	Node  string `json:"node"`
+	Hints int    `json:"hints"`
+}
+
+func (node *Node) Hints() []HintStatus {
+	counts := make(map[string]int)
+	for _, item := range node.replica.hints.ScanPrefix("", 0) {
+		id, _, found := strings.Cut(item.Key, "/")
+		if found {
+			counts[id]++
+		}
+	}
+
+	status := []HintStatus{}
+	for id, count := range counts {
+		status = append(status, HintStatus{Node: id, Hints: count})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Node < status[j].Node
+	})
+	return status
+}
@@ -0,0 +1,341 @@
+package leaderless
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const ReplicaPath = "/leaderless/replica"
+
+const replicaRpcTimeout = 2 * time.Second
+
+var ErrQuorumNotReached = errors.New("not enough replicas answered to reach the quorum")
+var ErrInvalidQuorum = errors.New("quorums must be between 1 and the replica count, which must not exceed the node count")
+
+type Config struct {
+	Replicas    int
+	ReadQuorum  int
+	WriteQuorum int
+}
+
+type Node struct {
+	selfId  string
+	peers   map[string]cluster.Peer
+	ring    *ring
+	config  Config
+	replica *replica
+	alive   func(id string) bool
+	client  *http.Client
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+type target struct {
+	node    string
+	hintFor string
+}
+
+type ReplicaWrite struct {
+	Record  Record `json:"record"`
+	HintFor string `json:"hintFor,omitempty"`
+}
+
+type readResult struct {
+	target target
+	record Record
+	err    error
+}
+
+func NewNode(self cluster.Peer, dir string, nodes []cluster.Peer, config Config, alive func(id string) bool) (*Node, error) {
+	node := &Node{
+		selfId:  self.Id,
+		peers:   make(map[string]cluster.Peer),
+		config:  config,
+		replica: newReplica(dir),
+		alive:   alive,
+		client:  &http.Client{Timeout: replicaRpcTimeout},
+		stop:    make(chan struct{}),
+	}
+
+	members := []cluster.Peer{self}
+	for _, peer := range nodes {
+		if peer.Id != self.Id {
+			node.peers[peer.Id] = peer
+			members = append(members, peer)
+		}
+	}
+	node.ring = newRing(members)
+
+	err := node.checkQuorum(config.ReadQuorum, config.WriteQuorum)
+	if err != nil {
+		return nil, err
+	}
+
+	node.replica.data.SetMerkleScopes(node.sharedWith)
+	node.running.Add(2)
+	go node.handOffHints()
+	go node.antiEntropy()
+	return node, nil
+}
+
+func (node *Node) Close() {
+	//the background work is finished before the stores it writes to are closed
+	close(node.stop)
+	node.running.Wait()
+	node.replica.close()
+}
+
+func (node *Node) checkQuorum(read int, write int) error {
+	replicas := node.config.Replicas
+	if replicas < 1 || replicas > node.ring.nodes || read < 1 || read > replicas || write < 1 || write > replicas {
+		return ErrInvalidQuorum
+	}
+	return nil
+}
+
+func (node *Node) isAlive(id string) bool {
+	return id == node.selfId || node.alive(id)
+}
+
+func (node *Node) targets(key string) ([]target, []string) {
+	list := node.ring.preferenceList(key)
+	count := min(node.config.Replicas, len(list))
+	spare := list[count:]
+
+	//a node that is down is covered by the next healthy one past the first N, which keeps a hint for it
+	targets := []target{}
+	for _, id := range list[:count] {
+		if node.isAlive(id) {
+			targets = append(targets, target{node: id})
+			continue
+		}
+		for len(spare) > 0 && !node.isAlive(spare[0]) {
+			spare = spare[1:]
+		}
+		if len(spare) > 0 {
+			targets = append(targets, target{node: spare[0], hintFor: id})
+			spare = spare[1:]
+		}
+	}
+	return targets, spare
+}
+
+func (node *Node) Get(key string, quorum int) (Record, error) {
+	if quorum == 0 {
+		quorum = node.config.ReadQuorum
+	}
+	err := node.checkQuorum(quorum, node.config.WriteQuorum)
+	if err != nil {
+		return Record{}, err
+	}
+
+	targets, _ := node.targets(key)
+	results := make(chan readResult, len(targets))
+	for _, t := range targets {
+		go func(t target) {
+			record, err := node.readFrom(t, key)
+			results <- readResult{target: t, record: record, err: err}
+		}(t)
+	}
+
+	answered := []readResult{}
+	succeeded := 0
+	var newest Record
+	for len(answered) < len(targets) && succeeded < quorum {
+		result := <-results
+		answered = append(answered, result)
+		if result.err != nil {
+			continue
+		}
+		succeeded++
+		kvstore.NodeClock().Update(result.record.Version.Timestamp)
+		if result.record.Version.After(newest.Version) {
+			newest = result.record
+		}
+	}
+
+	//the replicas that answer late are still compared, repairs never hold up the read
+	node.running.Add(1)
+	go node.repair(key, results, answered, len(targets))
+
+	if succeeded < quorum {
+		return Record{}, fmt.Errorf("%w: %d of %d reads", ErrQuorumNotReached, succeeded, quorum)
+	}
+	newest.Key = key
+	return newest, nil
+}
+
+func (node *Node) repair(key string, results chan readResult, answered []readResult, expected int) {
+	defer node.running.Done()
+
+	for len(answered) < expected {
+		answered = append(answered, <-results)
+	}
+
+	var newest Record
+	for _, result := range answered {
+		if result.err == nil && result.record.Version.After(newest.Version) {
+			newest = result.record
+		}
+	}
+	if newest.Version.IsZero() {
+		return
+	}
+
+	for _, result := range answered {
+		if result.err != nil || !newest.Version.After(result.record.Version) {
+			continue
+		}
+		err := node.writeTo(result.target, newest)
+		if err != nil {
+			log.Printf("leaderless: repairing %q on %s: %v", key, result.target.node, err)
+		} else {
+			log.Printf("leaderless: repaired %q on %s", key, result.target.node)
+		}
+	}
+}
+
+func (node *Node) Put(key string, value string, quorum int) (Version, error) {
+	return node.write(Record{Key: key, Value: value}, quorum)
+}
+
+func (node *Node) Delete(key string, quorum int) (Version, error) {
+	return node.write(Record{Key: key, Deleted: true}, quorum)
+}
+
+func (node *Node) write(record Record, quorum int) (Version, error) {
+	if quorum == 0 {
+		quorum = node.config.WriteQuorum
+	}
+	err := node.checkQuorum(node.config.ReadQuorum, quorum)
+	if err != nil {
+		return Version{}, err
+	}
+
+	//last writer wins, the hybrid clock keeps a write after every version the coordinator has seen
+	record.Version = Version{Timestamp: kvstore.NodeClock().Now(), Node: node.selfId}
+
+	targets, spare := node.targets(record.Key)
+	var spareMutex sync.Mutex
+	nextSpare := func() (string, bool) {
+		spareMutex.Lock()
+		defer spareMutex.Unlock()
+		if len(spare) == 0 {
+			return "", false
+		}
+		id := spare[0]
+		spare = spare[1:]
+		return id, true
+	}
+
+	results := make(chan error, len(targets))
+	for _, t := range targets {
+		go func(t target) {
+			err := node.writeTo(t, record)
+			//a replica that fails to answer is covered by a stand-in the same way a dead one is
+			for err != nil {
+				id, ok := nextSpare()
+				if !ok {
+					break
+				}
+				hintFor := t.hintFor
+				if hintFor == "" {
+					hintFor = t.node
+				}
+				err = node.writeTo(target{node: id, hintFor: hintFor}, record)
+			}
+			results <- err
+		}(t)
+	}
+
+	acknowledged := 0
+	for i := 0; i < len(targets) && acknowledged < quorum; i++ {
+		if <-results == nil {
+			acknowledged++
+		}
+	}
+	if acknowledged < quorum {
+		return record.Version, fmt.Errorf("%w: %d of %d writes", ErrQuorumNotReached, acknowledged, quorum)
+	}
+	return record.Version, nil
+}
+
+func (node *Node) readFrom(t target, key string) (Record, error) {
+	if t.node == node.selfId {
+		return node.replica.read(key, t.hintFor), nil
+	}
+
+	query := url.Values{"key": {key}}
+	if t.hintFor != "" {
+		query.Set("for", t.hintFor)
+	}
+	var record Record
+	err := node.call(t.node, http.MethodGet, ReplicaPath+"?"+query.Encode(), nil, &record)
+	return record, err
+}
+
+func (node *Node) writeTo(t target, record Record) error {
+	if t.node == node.selfId {
+		return node.Apply(ReplicaWrite{Record: record, HintFor: t.hintFor})
+	}
+	return node.call(t.node, http.MethodPost, ReplicaPath, ReplicaWrite{Record: record, HintFor: t.hintFor}, nil)
+}
+
+func (node *Node) call(id string, method string, path string, request any, response any) error {
+	peer, exists := node.peers[id]
+	if !exists {
+		return fmt.Errorf("unknown node %s", id)
+	}
+
+	var body bytes.Buffer
+	if request != nil {
+		err := json.NewEncoder(&body).Encode(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	httpRequest, err := http.NewRequest(method, peer.Address+path, &body)
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := node.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", id, httpResponse.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (node *Node) Apply(write ReplicaWrite) error {
+	_, err := kvstore.NodeClock().Update(write.Record.Version.Timestamp)
+	if err != nil {
+		return err
+	}
+	if write.HintFor != "" && write.HintFor != node.selfId {
+		return node.replica.hint(write.HintFor, write.Record)
+	}
+	return node.replica.apply(write.Record)
+}
+
+func (node *Node) Read(key string, hintFor string) Record {
+	return node.replica.read(key, hintFor)
+}
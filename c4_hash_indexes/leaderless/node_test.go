@@ -0,0 +1,138 @@
+package leaderless
+
+import (
+	"encoding/json"
+	"errors"
+	"keyvault/cluster"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testCluster struct {
+	nodes map[string]*Node
+	down  map[string]bool
+	mutex sync.Mutex
+}
+
+func (c *testCluster) isDown(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.down[id]
+}
+
+func (c *testCluster) setDown(id string, down bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.down[id] = down
+}
+
+func (c *testCluster) handler(id string) http.Handler {
+	//the same replica routes the main package serves, and a node that is down answers nothing
+	mux := http.NewServeMux()
+	mux.HandleFunc(ReplicaPath, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(c.nodes[id].Read(req.URL.Query().Get("key"), req.URL.Query().Get("for")))
+			return
+		}
+		var write ReplicaWrite
+		err := json.NewDecoder(req.Body).Decode(&write)
+		if err == nil {
+			err = c.nodes[id].Apply(write)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+		}
+	})
+	mux.HandleFunc(MerklePath, func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		level, _ := strconv.Atoi(query.Get("level"))
+		positions := []int{}
+		for _, value := range strings.Split(query.Get("positions"), ",") {
+			position, _ := strconv.Atoi(value)
+			positions = append(positions, position)
+		}
+		json.NewEncoder(w).Encode(c.nodes[id].Merkle(query.Get("peer"), level, positions))
+	})
+	mux.HandleFunc(MerkleBucketPath, func(w http.ResponseWriter, req *http.Request) {
+		bucket, _ := strconv.Atoi(req.URL.Query().Get("bucket"))
+		json.NewEncoder(w).Encode(c.nodes[id].Bucket(req.URL.Query().Get("peer"), bucket))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if c.isDown(id) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func startTestCluster(t *testing.T, ids []string, config Config) *testCluster {
+	c := &testCluster{nodes: make(map[string]*Node), down: make(map[string]bool)}
+
+	peers := []cluster.Peer{}
+	for _, id := range ids {
+		server := httptest.NewServer(c.handler(id))
+		t.Cleanup(server.Close)
+		peers = append(peers, cluster.Peer{Id: id, Address: server.URL})
+	}
+
+	for _, peer := range peers {
+		node, err := NewNode(peer, t.TempDir(), peers, config, func(id string) bool {
+			return !c.isDown(id)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(node.Close)
+		c.nodes[peer.Id] = node
+	}
+	return c
+}
+
+func TestQuorumWriteWithANodeDown(t *testing.T) {
+	c := startTestCluster(t, []string{"n1", "n2", "n3", "n4"}, Config{Replicas: 3, ReadQuorum: 2, WriteQuorum: 2})
+
+	//the first node on the key's list coordinates, the second is down and the fourth stands in for it
+	list := c.nodes["n1"].ring.preferenceList("key")
+	coordinator, down, standIn := c.nodes[list[0]], list[1], c.nodes[list[3]]
+	c.setDown(down, true)
+
+	_, err := coordinator.Put("key", "v1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := coordinator.Get("key", 0)
+	if err != nil || record.Value != "v1" {
+		t.Fatalf("reading with a node down gave %v %v", record, err)
+	}
+	if record := c.nodes[down].Read("key", ""); record.Value != "" {
+		t.Fatalf("the node that was down holds %v", record)
+	}
+	if hints := standIn.Hints(); len(hints) != 1 || hints[0] != (HintStatus{Node: down, Hints: 1}) {
+		t.Fatalf("the stand-in holds hints %v", hints)
+	}
+
+	//with the node back the hint is delivered and dropped
+	c.setDown(down, false)
+	standIn.handOffTo(down)
+	if record := c.nodes[down].Read("key", ""); record.Value != "v1" {
+		t.Fatalf("after the handoff the node holds %v", record)
+	}
+	if hints := standIn.Hints(); len(hints) != 0 {
+		t.Fatalf("the stand-in kept hints %v", hints)
+	}
+
+	//with every other node down, only the coordinator acknowledges
+	for _, id := range list[1:] {
+		c.setDown(id, true)
+	}
+	_, err = coordinator.Put("key", "v2", 0)
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("a write with only the coordinator up gave %v", err)
+	}
+}
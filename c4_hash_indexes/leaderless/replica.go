@@ -0,0 +1,155 @@
+package leaderless
+
+import (
+	"encoding/json"
+	"keyvault/kvstore"
+	"path/filepath"
+	"sync"
+)
+
+type Version struct {
+	Timestamp kvstore.Timestamp `json:"timestamp"`
+	Node      string            `json:"node"`
+}
+
+func (version Version) After(other Version) bool {
+	//the node id breaks ties, so every replica picks the same winner
+	if version.Timestamp != other.Timestamp {
+		return version.Timestamp.After(other.Timestamp)
+	}
+	return version.Node > other.Node
+}
+
+func (version Version) IsZero() bool {
+	return version.Timestamp.IsZero() && version.Node == ""
+}
+
+type Record struct {
+	Key     string  `json:"key"`
+	Value   string  `json:"value,omitempty"`
+	Deleted bool    `json:"deleted,omitempty"`
+	Version Version `json:"version"`
+}
+
+type replica struct {
+	data  *kvstore.KvStore
+	hints *kvstore.KvStore
+	mutex sync.Mutex
+}
+
+func newReplica(dir string) *replica {
+	return &replica{
+		data:  kvstore.NewKvStore(filepath.Join(dir, "data")),
+		hints: kvstore.NewKvStore(filepath.Join(dir, "hints")),
+	}
+}
+
+func (replica *replica) close() {
+	replica.data.Close()
+	replica.hints.Close()
+}
+
+func hintKey(target string, key string) string {
+	return target + "/" + key
+}
+
+func readRecord(store *kvstore.KvStore, key string) (Record, bool) {
+	value := store.Get(key)
+	if value == nil {
+		return Record{}, false
+	}
+
+	var record Record
+	err := json.Unmarshal([]byte(*value), &record)
+	if err != nil {
+		return Record{}, false
+	}
+	return record, true
+}
+
+func writeRecord(store *kvstore.KvStore, key string, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(key, string(data))
+	return err
+}
+
+func (replica *replica) read(key string, hintFor string) Record {
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+
+	record, _ := readRecord(replica.data, key)
+	//a stand-in answers with what it holds for the node it covers when that is newer
+	if hintFor != "" {
+		hinted, exists := readRecord(replica.hints, hintKey(hintFor, key))
+		if exists && hinted.Version.After(record.Version) {
+			return hinted
+		}
+	}
+	if record.Key == "" {
+		record.Key = key
+	}
+	return record
+}
+
+func (replica *replica) apply(record Record) error {
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+
+	//deletes are kept as tombstones, otherwise a stale replica would bring the key back
+	current, exists := readRecord(replica.data, record.Key)
+	if exists && !record.Version.After(current.Version) {
+		return nil
+	}
+	return writeRecord(replica.data, record.Key, record)
+}
+
+func (replica *replica) bucket(bucket int, peer string) []Record {
+	records := []Record{}
+	for _, item := range replica.data.BucketKeys(bucket, peer) {
+		var record Record
+		if json.Unmarshal([]byte(item.Value), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (replica *replica) hint(target string, record Record) error {
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+
+	key := hintKey(target, record.Key)
+	current, exists := readRecord(replica.hints, key)
+	if exists && !record.Version.After(current.Version) {
+		return nil
+	}
+	return writeRecord(replica.hints, key, record)
+}
+
+func (replica *replica) hintsFor(target string) []Record {
+	records := []Record{}
+	for _, item := range replica.hints.ScanPrefix(target+"/", 0) {
+		var record Record
+		if json.Unmarshal([]byte(item.Value), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (replica *replica) dropHint(target string, record Record) error {
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+
+	//a newer write may have been hinted while this one was handed off, it stays
+	key := hintKey(target, record.Key)
+	current, exists := readRecord(replica.hints, key)
+	if !exists || current.Version.After(record.Version) {
+		return nil
+	}
+	_, err := replica.hints.Delete(key)
+	return err
+}
@@ -0,0 +1,67 @@
+package leaderless
+
+import (
+	"fmt"
+	"hash/fnv"
+	"keyvault/cluster"
+	"sort"
+)
+
+const ringVirtualNodes = 64
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+type ring struct {
+	points []ringPoint
+	nodes  int
+}
+
+func hashOf(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+
+	//fnv barely moves the high bits for short names that differ in one byte, this spreads them over the ring
+	sum := hash.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return sum
+}
+
+func newRing(nodes []cluster.Peer) *ring {
+	//every node sits at many points so keys spread evenly and a missing node's share is split up
+	ring := &ring{nodes: len(nodes)}
+	for _, node := range nodes {
+		for i := 0; i < ringVirtualNodes; i++ {
+			ring.points = append(ring.points, ringPoint{hash: hashOf(fmt.Sprintf("%s#%d", node.Id, i)), node: node.Id})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+func (ring *ring) preferenceList(key string) []string {
+	//walking clockwise from the key, every distinct node in the order it is met
+	hash := hashOf(key)
+	start := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i].hash >= hash
+	})
+
+	nodes := []string{}
+	seen := make(map[string]bool)
+	for i := 0; i < len(ring.points) && len(nodes) < ring.nodes; i++ {
+		point := ring.points[(start+i)%len(ring.points)]
+		if !seen[point.node] {
+			seen[point.node] = true
+			nodes = append(nodes, point.node)
+		}
+	}
+	return nodes
+}
@@ -2,12 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"io"
+	"keyvault/cluster"
 	"keyvault/kvstore"
+	"keyvault/leaderless"
+	"keyvault/partition"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-var store *kvstore.KvStore = kvstore.NewKvStore()
+var store *kvstore.KvStore
+
+const generationHeader = "X-Kv-Generation"
+const clientIdHeader = "X-Kv-Client-Id"
+const sequenceHeader = "X-Kv-Sequence"
 
 type PutRequest struct {
 	Key   string `json:"key"`
@@ -26,8 +38,84 @@ func handleHttpError(w http.ResponseWriter, e error) {
 	http.Error(w, e.Error(), 400)
 }
 
+func handleWriteError(w http.ResponseWriter, e error) {
+	if errors.Is(e, kvstore.ErrReadOnlyFollower) || errors.Is(e, kvstore.ErrNotLeader) {
+		http.Error(w, e.Error()+", send writes to "+store.LeaderUrl(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(e, kvstore.ErrMissingSequence) {
+		handleHttpError(w, e)
+		return
+	}
+	if errors.Is(e, kvstore.ErrStaleGeneration) || errors.Is(e, kvstore.ErrStaleSequence) {
+		http.Error(w, e.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(e, kvstore.ErrTransferInProgress) {
+		retryLater(w, e.Error())
+		return
+	}
+	if errors.Is(e, kvstore.ErrCommitTimeout) || errors.Is(e, kvstore.ErrLeadershipLost) || errors.Is(e, kvstore.ErrReadNotConfirmed) || errors.Is(e, kvstore.ErrTransferTimeout) {
+		http.Error(w, e.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, e.Error(), 500)
+}
+
+func checkGeneration(w http.ResponseWriter, req *http.Request) bool {
+	value := req.Header.Get(generationHeader)
+	if value == "" {
+		return true
+	}
+
+	generation, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		handleHttpError(w, err)
+		return false
+	}
+
+	err = store.CheckGeneration(generation)
+	if err != nil {
+		handleWriteError(w, err)
+		return false
+	}
+	return true
+}
+
+func requestIdentity(req *http.Request) (string, uint64, error) {
+	//retries carry the same client id and sequence so they are only applied once
+	clientId := req.Header.Get(clientIdHeader)
+	value := req.Header.Get(sequenceHeader)
+	if value == "" {
+		return clientId, 0, nil
+	}
+
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	return clientId, sequence, err
+}
+
 func httpHandler(w http.ResponseWriter, req *http.Request) {
 	method := req.Method
+	setLeaderHint(w)
+
+	//followers pass writes on to the leader and relay its answer
+	if (method == http.MethodPost || method == http.MethodDelete) && forwardToLeader(w, req) {
+		return
+	}
+
+	//clients echo the generation back so writes meant for an old leader are refused
+	if generation := store.Generation(); generation > 0 {
+		w.Header().Set(generationHeader, strconv.FormatUint(generation, 10))
+	}
+	if (method == http.MethodPost || method == http.MethodDelete) && !checkGeneration(w, req) {
+		return
+	}
+
+	clientId, sequence, err := requestIdentity(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
 
 	if method == http.MethodGet {
 		query := req.URL.Query()
@@ -38,11 +126,31 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		consistency, err := kvstore.ParseConsistency(query.Get("consistency"))
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		//only the leader can tell whether it still is one, so linearizable reads go there
+		if consistency == kvstore.ConsistencyLinearizable && forwardToLeader(w, req) {
+			return
+		}
+
+		if !followerReadReady(w, req) {
+			return
+		}
+
+		value, version, err := store.GetConsistent(key, consistency)
+		if err != nil {
+			handleWriteError(w, err)
+			return
+		}
+
 		w.Header().Add("Content-Type", "application/json")
 		encoder := json.NewEncoder(w)
 
-		value := store.Get(key)
-		encoder.Encode(map[string]string{
+		encoder.Encode(map[string]any{
 			"key": key,
 			"value": func() string {
 				if value == nil {
@@ -50,6 +158,7 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 				}
 				return *value
 			}(),
+			"version": version,
 		})
 	}
 
@@ -57,13 +166,18 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 		body, _ := io.ReadAll(req.Body)
 
 		var request PutRequest
-		err := json.Unmarshal(body, &request)
+		err = json.Unmarshal(body, &request)
 		if err != nil || !request.isValid() {
 			handleHttpError(w, err)
 			return
 		}
 
-		store.Put(request.Key, request.Value)
+		index, err := store.PutIdempotent(clientId, sequence, request.Key, request.Value)
+		if err != nil {
+			handleWriteError(w, err)
+			return
+		}
+		writeIndexToken(w, index)
 	}
 
 	if method == http.MethodDelete {
@@ -75,12 +189,300 @@ func httpHandler(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		store.Delete(key)
+		index, err := store.DeleteIdempotent(clientId, sequence, key)
+		if err != nil {
+			handleWriteError(w, err)
+			return
+		}
+		writeIndexToken(w, index)
 	}
 
 }
 
+func exportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	format, err := kvstore.ParseExportFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	if format == kvstore.ExportFormatCSV {
+		w.Header().Add("Content-Type", "text/csv")
+	} else {
+		w.Header().Add("Content-Type", "application/x-ndjson")
+	}
+
+	store.Export(w, format)
+}
+
+func importHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	setLeaderHint(w)
+	if forwardToLeader(w, req) {
+		return
+	}
+
+	format, err := kvstore.ParseExportFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	count, err := store.Import(req.Body, format)
+	if errors.Is(err, kvstore.ErrReadOnlyFollower) || errors.Is(err, kvstore.ErrNotLeader) {
+		handleWriteError(w, err)
+		return
+	}
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"imported": count,
+	})
+}
+
+func scanBounds(req *http.Request) (string, string, int, error) {
+	query := req.URL.Query()
+	start, end := query.Get("start"), query.Get("end")
+	if prefix := query.Get("prefix"); prefix != "" {
+		start, end = prefix, kvstore.PrefixEnd(prefix)
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return "", "", 0, errors.New("limit must be a positive number")
+		}
+	}
+	return start, end, limit, nil
+}
+
+func scanHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	start, end, limit, err := scanBounds(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.Scan(start, end, limit))
+}
+
 func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	dir := flag.String("dir", "dat", "data directory")
+	leader := flag.String("leader", "", "url of a leader to replicate from, makes this node a read-only follower")
+	nodeId := flag.String("id", "node1", "id of this node in the cluster")
+	peers := flag.String("peers", "", "comma separated peers as id=address, e.g. node2=localhost:8091")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 1*time.Second, "how often heartbeats are sent to peers")
+	suspectTimeout := flag.Duration("suspect-timeout", 3*time.Second, "silence after which a peer is suspected")
+	deadTimeout := flag.Duration("dead-timeout", 10*time.Second, "silence after which a peer is considered dead")
+	raft := flag.Bool("raft", false, "replicate writes to the peers with raft, committing once a majority has them")
+	join := flag.Bool("join", false, "start without any members or partitions and wait for the cluster to add this node")
+	clusterFile := flag.String("cluster", "", "json file listing the cluster nodes, used instead of -peers")
+	partitionCount := flag.Int("partitions", 0, "split the keyspace into this many partitions spread over the cluster nodes")
+	ranges := flag.Bool("ranges", false, "partition the keyspace into ordered key ranges that split as they grow, instead of by hash")
+	splitKeys := flag.Int("split-keys", 10000, "with -ranges, split a range once it holds more keys than this, 0 turns it off")
+	splitQps := flag.Float64("split-qps", 1000, "with -ranges, split a range once it serves more requests a second than this, 0 turns it off")
+	leaderlessMode := flag.Bool("leaderless", false, "replicate every key to -n nodes picked by consistent hashing, without a leader")
+	replicaCount := flag.Int("n", 3, "with -leaderless, how many nodes keep a copy of each key")
+	readQuorum := flag.Int("r", 2, "with -leaderless, how many replicas must answer a read")
+	writeQuorum := flag.Int("w", 2, "with -leaderless, how many replicas must acknowledge a write")
+	gossipMembership := flag.Bool("gossip", false, "track membership with SWIM gossip through a few seeds instead of heartbeats to every peer")
+	reapTimeout := flag.Duration("reap-timeout", 5*time.Minute, "with -gossip, how long members that died or left are remembered before they are forgotten")
+	advertise := flag.String("advertise", "", "address other nodes reach this one at, taken from -cluster when it lists this node")
+	flag.Parse()
+
+	addrSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "addr" {
+			addrSet = true
+		}
+	})
+
+	if (*raft || *join) && *leader != "" {
+		log.Fatal("-raft and -leader cannot be used together")
+	}
+	if *partitionCount > 0 && *ranges {
+		log.Fatal("-partitions and -ranges cannot be used together")
+	}
+	if (*partitionCount > 0 || *ranges) && (*raft || *leader != "") {
+		log.Fatal("-partitions and -ranges cannot be combined with -raft or -leader")
+	}
+	if *leaderlessMode && (*raft || *join || *leader != "" || *partitionCount > 0 || *ranges) {
+		log.Fatal("-leaderless cannot be combined with -raft, -join, -leader, -partitions or -ranges")
+	}
+
+	clusterPeers, err := cluster.ParsePeers(*peers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *clusterFile != "" {
+		config, err := cluster.LoadConfig(*clusterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clusterPeers = config.Nodes
+
+		//without -addr the node listens on the port the config gives it
+		if node, found := config.Node(*nodeId); found && !addrSet {
+			*addr, err = node.ListenAddress()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if node, found := config.Node(*nodeId); found && *advertise == "" {
+			*advertise = node.Address
+		}
+	}
+
+	if *gossipMembership {
+		if *advertise == "" {
+			log.Fatal("-gossip needs -advertise, or a -cluster file that lists this node")
+		}
+		self, err := cluster.NewPeer(*nodeId, *advertise)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		//the configured peers are only seeds, the rest of the cluster is learned from them
+		gossip = cluster.NewGossip(self, clusterPeers, cluster.GossipConfig{
+			Interval:       *heartbeatInterval,
+			SuspectTimeout: *deadTimeout,
+			ReapTimeout:    *reapTimeout,
+		})
+		liveness = gossip
+	} else {
+		heartbeat = cluster.NewHeartbeat(*nodeId, clusterPeers, cluster.HeartbeatConfig{
+			Interval:       *heartbeatInterval,
+			SuspectTimeout: *suspectTimeout,
+			DeadTimeout:    *deadTimeout,
+		})
+		liveness = heartbeat
+	}
+	liveness.Subscribe(func(change cluster.LivenessChange) {
+		log.Printf("peer %s is %s, was %s", change.Peer.Id, change.Current, change.Previous)
+	})
+	if gossip != nil {
+		gossip.Start()
+		leaveOnShutdown()
+	} else {
+		heartbeat.Start()
+	}
+
+	if *partitionCount > 0 || *ranges {
+		self := cluster.Peer{Id: *nodeId}
+		if *advertise != "" {
+			self, err = cluster.NewPeer(*nodeId, *advertise)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		partitions, err = partition.NewNode(self, *dir, clusterPeers, partition.Config{
+			Count:     *partitionCount,
+			Ranges:    *ranges,
+			Join:      *join,
+			SplitKeys: *splitKeys,
+			SplitQps:  *splitQps,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		//each partition has its own store, so only keyed requests are served in this mode
+		http.HandleFunc("/", partitionedHandler)
+		http.HandleFunc("/partitions", partitionTableHandler)
+		http.HandleFunc("/partitions/nodes", partitionNodesHandler)
+		http.HandleFunc("/partitions/rebalance", rebalanceHandler)
+		http.HandleFunc("/partitions/{partition}", partitionStatusHandler)
+		http.HandleFunc("/partitions/{partition}/changes", partitionChangesHandler)
+		http.HandleFunc("/partitions/{partition}/copy", partitionActionHandler(copyPartition))
+		http.HandleFunc("/partitions/{partition}/freeze", partitionActionHandler(freezePartition))
+		http.HandleFunc("/partitions/{partition}/promote", partitionActionHandler(promotePartition))
+		http.HandleFunc("/partitions/{partition}/split", partitionActionHandler(splitPartition))
+		http.HandleFunc("/partitions/{partition}/split/prepare", prepareSplitHandler)
+		http.HandleFunc("/partitions/{partition}/split/finish", partitionActionHandler(finishSplit))
+		http.HandleFunc("/partitions/{partition}/scan", partitionScanHandler)
+		http.HandleFunc("/scan", partitionedScanHandler)
+		http.HandleFunc("/batch", partitionedBatchHandler)
+		handleCluster()
+		http.ListenAndServe(*addr, withClock(http.DefaultServeMux))
+		return
+	}
+
+	if *leaderlessMode {
+		self := cluster.Peer{Id: *nodeId}
+		replicas, err = leaderless.NewNode(self, *dir, clusterPeers, leaderless.Config{
+			Replicas:    *replicaCount,
+			ReadQuorum:  *readQuorum,
+			WriteQuorum: *writeQuorum,
+		}, func(id string) bool {
+			return liveness.PeerStatus(id).Reachable()
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		//any node coordinates any request, replicas only talk to each other through the replica path
+		http.HandleFunc("/", leaderlessHandler)
+		http.HandleFunc(leaderless.ReplicaPath, replicaHandler)
+		http.HandleFunc("/leaderless/hints", hintsHandler)
+		http.HandleFunc(leaderless.MerklePath, merkleHandler)
+		http.HandleFunc(leaderless.MerkleBucketPath, merkleBucketHandler)
+		handleCluster()
+		http.ListenAndServe(*addr, withClock(http.DefaultServeMux))
+		return
+	}
+
+	store = kvstore.NewKvStore(*dir)
+	if *leader != "" {
+		store.FollowLeader(*leader)
+	}
+	if *join {
+		err = store.JoinRaft(*nodeId)
+	} else if *raft {
+		err = store.EnableRaft(*nodeId, clusterPeers)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	http.HandleFunc("/", httpHandler)
-	http.ListenAndServe(":8090", nil)
+	http.HandleFunc("/export", exportHandler)
+	http.HandleFunc("/scan", scanHandler)
+	http.HandleFunc("/batch", batchHandler)
+	http.HandleFunc("/import", importHandler)
+	http.HandleFunc("/changes", changesHandler)
+	http.HandleFunc("/watch", watchHandler)
+	handleCluster()
+	http.HandleFunc(kvstore.RaftRequestVotePath, raftHandler(store.HandleRequestVote))
+	http.HandleFunc(kvstore.RaftAppendEntriesPath, raftHandler(store.HandleAppendEntries))
+	http.HandleFunc(kvstore.RaftInstallSnapshotPath, raftHandler(store.HandleInstallSnapshot))
+	http.HandleFunc(kvstore.RaftTimeoutNowPath, raftHandler(store.HandleTimeoutNow))
+	http.HandleFunc("/raft/status", raftStatusHandler)
+	http.HandleFunc("/cluster/members", membersHandler)
+	http.HandleFunc("/cluster/members/promote", promoteHandler)
+	http.HandleFunc("/cluster/leader", transferLeaderHandler)
+	http.ListenAndServe(*addr, withClock(http.DefaultServeMux))
 }
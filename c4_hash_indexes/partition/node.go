@@ -0,0 +1,405 @@
+package partition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const partitionRpcTimeout = 5 * time.Second
+
+var ErrUnknownOwner = errors.New("partition owner is not a known peer")
+var ErrNoOwner = errors.New("partition has no owner yet")
+var ErrNotOwner = errors.New("this node does not own the partition")
+var ErrPartitionFrozen = errors.New("partition is moving to another node")
+var ErrUnknownPartition = errors.New("no such partition")
+var ErrStaleTable = errors.New("partition table is older than the one in use")
+
+type Node struct {
+	selfId    string
+	dir       string
+	peers     map[string]cluster.Peer
+	table     *Table
+	stores    map[int]*kvstore.KvStore
+	frozen    map[int]bool
+	writes    map[int]*sync.RWMutex
+	rebalance *Rebalance
+	splitting bool
+	hits      map[int]uint64
+	config    Config
+	client    *http.Client
+	mutex     sync.Mutex
+}
+
+type Config struct {
+	Count     int
+	Ranges    bool
+	Join      bool
+	SplitKeys int
+	SplitQps  float64
+}
+
+type Route struct {
+	Partition int
+	Owner     cluster.Peer
+	Local     bool
+}
+
+type PartitionStatus struct {
+	Partition int    `json:"partition"`
+	LastIndex uint64 `json:"lastIndex"`
+	Owned     bool   `json:"owned"`
+	Following bool   `json:"following"`
+	Frozen    bool   `json:"frozen"`
+	Range     *Range `json:"range,omitempty"`
+	Keys      int    `json:"keys"`
+}
+
+func NewNode(self cluster.Peer, dir string, peers []cluster.Peer, config Config) (*Node, error) {
+	node := &Node{
+		selfId: self.Id,
+		dir:    dir,
+		peers:  make(map[string]cluster.Peer),
+		stores: make(map[int]*kvstore.KvStore),
+		frozen: make(map[int]bool),
+		writes: make(map[int]*sync.RWMutex),
+		hits:   make(map[int]uint64),
+		config: config,
+		client: &http.Client{Timeout: partitionRpcTimeout},
+	}
+
+	nodes := []cluster.Peer{self}
+	for _, peer := range peers {
+		if peer.Id == self.Id {
+			continue
+		}
+		node.peers[peer.Id] = peer
+		nodes = append(nodes, peer)
+	}
+
+	table, err := node.loadTable()
+	if err != nil {
+		return nil, err
+	}
+
+	//a joining node owns nothing until a rebalance hands it partitions
+	if table == nil && config.Join && config.Ranges {
+		table = &Table{Count: 1, Owners: []string{""}, Nodes: nodes, Ranges: []Range{{Partition: 0}}}
+	} else if table == nil && config.Join {
+		table = &Table{Count: config.Count, Owners: make([]string, config.Count), Nodes: nodes}
+	} else if table == nil && config.Ranges {
+		table, err = NewRangeTable(nodes)
+	} else if table == nil {
+		table, err = NewTable(config.Count, nodes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if table.IsRanged() != config.Ranges {
+		return nil, fmt.Errorf("partition table in %s does not match -ranges", dir)
+	}
+	if !config.Ranges && table.Count != config.Count {
+		return nil, fmt.Errorf("partition table in %s has %d partitions, not %d", dir, table.Count, config.Count)
+	}
+	node.table = table
+	node.learnNodes(table.Nodes)
+
+	//partitions this node owns are opened up front so their compaction runs from the start
+	for _, partition := range table.OwnedBy(self.Id) {
+		node.Store(partition)
+	}
+
+	if config.Ranges {
+		go node.watchLoad()
+	}
+	return node, nil
+}
+
+func (node *Node) tablePath() string {
+	return filepath.Join(node.dir, "partitions.json")
+}
+
+func (node *Node) loadTable() (*Table, error) {
+	bytes, err := os.ReadFile(node.tablePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	err = json.Unmarshal(bytes, &table)
+	if err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+func (node *Node) saveTable() error {
+	data, err := json.Marshal(node.table)
+	if err != nil {
+		return err
+	}
+
+	//the table decides where every key lives, so it is replaced atomically
+	os.MkdirAll(node.dir, 0755)
+	tempPath := node.tablePath() + ".tmp"
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, node.tablePath())
+}
+
+func (node *Node) learnNodes(nodes []cluster.Peer) {
+	for _, peer := range nodes {
+		if peer.Id != node.selfId && peer.Address != "" {
+			node.peers[peer.Id] = peer
+		}
+	}
+}
+
+func (node *Node) Table() Table {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return *node.table.clone()
+}
+
+func (node *Node) InstallTable(table Table) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if table.Version <= node.table.Version {
+		return ErrStaleTable
+	}
+	if table.IsRanged() != node.table.IsRanged() {
+		return errors.New("partition table mixes hash and range partitioning")
+	}
+	//splits only ever add partitions, but hash partitions are fixed
+	if table.Count < node.table.Count || (!table.IsRanged() && table.Count != node.table.Count) || len(table.Owners) != table.Count {
+		return fmt.Errorf("partition table has %d partitions, not %d", table.Count, node.table.Count)
+	}
+	if table.IsRanged() && len(table.Ranges) != table.Count {
+		return fmt.Errorf("partition table has %d ranges for %d partitions", len(table.Ranges), table.Count)
+	}
+
+	node.table = table.clone()
+	node.learnNodes(table.Nodes)
+	return node.saveTable()
+}
+
+func (node *Node) Route(key string) (Route, error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	partition, owner := node.table.Owner(key)
+	if owner == "" {
+		return Route{Partition: partition}, ErrNoOwner
+	}
+	if owner == node.selfId {
+		node.hits[partition]++
+		return Route{Partition: partition, Owner: cluster.Peer{Id: owner}, Local: true}, nil
+	}
+
+	peer, exists := node.peers[owner]
+	if !exists {
+		return Route{Partition: partition}, fmt.Errorf("%w: %s", ErrUnknownOwner, owner)
+	}
+	return Route{Partition: partition, Owner: peer}, nil
+}
+
+func (node *Node) Store(partition int) *kvstore.KvStore {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.storeLocked(partition)
+}
+
+func (node *Node) partitionDir(partition int) string {
+	return filepath.Join(node.dir, fmt.Sprintf("partition_%d", partition))
+}
+
+func (node *Node) storeLocked(partition int) *kvstore.KvStore {
+	//each partition keeps its own wal in its own directory
+	store, exists := node.stores[partition]
+	if !exists {
+		store = kvstore.NewKvStore(node.partitionDir(partition))
+		node.stores[partition] = store
+	}
+	return store
+}
+
+func (node *Node) writeLock(partition int) *sync.RWMutex {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	lock, exists := node.writes[partition]
+	if !exists {
+		lock = &sync.RWMutex{}
+		node.writes[partition] = lock
+	}
+	return lock
+}
+
+func (node *Node) Write(partition int, keys []string, write func(store *kvstore.KvStore) error) error {
+	//a freeze waits for the writes already running, so a moving partition has a last index that holds still
+	lock := node.writeLock(partition)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	node.mutex.Lock()
+	if node.frozen[partition] {
+		node.mutex.Unlock()
+		return ErrPartitionFrozen
+	}
+	//a split may have handed a key to another partition since it was routed
+	for _, key := range keys {
+		current, owner := node.table.Owner(key)
+		if current != partition || owner != node.selfId {
+			node.mutex.Unlock()
+			return ErrNotOwner
+		}
+	}
+	store := node.storeLocked(partition)
+	node.mutex.Unlock()
+
+	return write(store)
+}
+
+func (node *Node) checkPartition(partition int) error {
+	if partition < 0 || partition >= node.table.Count {
+		return ErrUnknownPartition
+	}
+	return nil
+}
+
+func (node *Node) Status(partition int) (PartitionStatus, error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	err := node.checkPartition(partition)
+	if err != nil {
+		return PartitionStatus{}, err
+	}
+
+	status := PartitionStatus{
+		Partition: partition,
+		Owned:     node.table.Owners[partition] == node.selfId,
+		Frozen:    node.frozen[partition],
+	}
+	r, ranged := node.table.Range(partition)
+	if ranged {
+		status.Range = &r
+	}
+	if store, exists := node.stores[partition]; exists {
+		status.LastIndex = store.LastIndex()
+		status.Following = store.IsFollower()
+		status.Keys = store.KeyCount(r.Start, r.End)
+	}
+	return status, nil
+}
+
+func (node *Node) Copy(partition int, from string) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	err := node.checkPartition(partition)
+	if err != nil {
+		return err
+	}
+	if node.table.Owners[partition] == node.selfId {
+		return fmt.Errorf("partition %d is already owned here", partition)
+	}
+
+	//whatever an earlier, abandoned move left behind is thrown away
+	node.dropLocked(partition)
+
+	store := node.storeLocked(partition)
+	store.FollowLeader(from + PartitionPath(partition))
+	log.Printf("partition %d: copying from %s", partition, from)
+	return nil
+}
+
+func (node *Node) Freeze(partition int, frozen bool) error {
+	err := node.checkPartition(partition)
+	if err != nil {
+		return err
+	}
+
+	lock := node.writeLock(partition)
+	lock.Lock()
+	defer lock.Unlock()
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	node.frozen[partition] = frozen
+	return nil
+}
+
+func (node *Node) Promote(partition int) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	store, exists := node.stores[partition]
+	if !exists {
+		return ErrUnknownPartition
+	}
+	store.StopFollowing()
+	return nil
+}
+
+func (node *Node) Drop(partition int) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	err := node.checkPartition(partition)
+	if err != nil {
+		return err
+	}
+	if node.table.Owners[partition] == node.selfId {
+		return fmt.Errorf("partition %d is still owned here", partition)
+	}
+
+	return node.dropLocked(partition)
+}
+
+func (node *Node) dropLocked(partition int) error {
+	if store, exists := node.stores[partition]; exists {
+		store.Close()
+		delete(node.stores, partition)
+	}
+	delete(node.frozen, partition)
+	return os.RemoveAll(node.partitionDir(partition))
+}
+
+func (node *Node) Observe(peer cluster.Peer, version uint64) {
+	node.mutex.Lock()
+	current := node.table.Version
+	node.mutex.Unlock()
+
+	//a node that answers with a newer table has seen a move this one missed
+	if version <= current {
+		return
+	}
+
+	go func() {
+		var table Table
+		err := remoteOps{node: node, peer: peer}.call(http.MethodGet, TablePath, nil, &table)
+		if err == nil {
+			err = node.InstallTable(table)
+		}
+		if err != nil && !errors.Is(err, ErrStaleTable) {
+			log.Printf("partition table %d from %s: %v", version, peer.Id, err)
+		}
+	}()
+}
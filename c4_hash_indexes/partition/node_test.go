@@ -0,0 +1,54 @@
+package partition
+
+import (
+	"errors"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestRouteToTheOwner(t *testing.T) {
+	peers := []cluster.Peer{{Id: "n1", Address: "http://localhost:1"}, {Id: "n2", Address: "http://localhost:2"}}
+	nodes := map[string]*Node{}
+	for _, peer := range peers {
+		node, err := NewNode(peer, t.TempDir(), peers, Config{Count: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[peer.Id] = node
+	}
+
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		partition, owner := nodes["n1"].table.Owner(key)
+
+		//the owner serves the key itself, every other node points at it
+		for id, node := range nodes {
+			route, err := node.Route(key)
+			if err != nil || route.Partition != partition || route.Local != (id == owner) {
+				t.Fatalf("%s routes %s to %+v %v", id, key, route, err)
+			}
+			if !route.Local && route.Owner != node.peers[owner] {
+				t.Fatalf("%s routes %s to %s, owned by %s", id, key, route.Owner.Id, owner)
+			}
+
+			err = node.Write(partition, []string{key}, func(store *kvstore.KvStore) error {
+				_, err := store.Put(key, "v")
+				return err
+			})
+			if (id == owner) != (err == nil) || (id != owner && !errors.Is(err, ErrNotOwner)) {
+				t.Fatalf("writing %s on %s gave %v", key, id, err)
+			}
+		}
+
+		//each partition keeps its keys in its own directory
+		if value := nodes[owner].Store(partition).Get(key); value == nil {
+			t.Fatalf("%s is missing from partition %d", key, partition)
+		}
+		if _, err := os.Stat(nodes[owner].partitionDir(partition)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
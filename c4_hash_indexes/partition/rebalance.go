@@ -0,0 +1,464 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const TablePath = "/partitions"
+
+const (
+	MovePending  = "pending"
+	MoveCopying  = "copying"
+	MoveSwitch   = "switching"
+	MoveDropping = "dropping"
+	MoveDone     = "done"
+	MoveFailed   = "failed"
+)
+
+const (
+	RebalanceRunning = "running"
+	RebalanceDone    = "done"
+	RebalanceFailed  = "failed"
+)
+
+const (
+	rebalancePollInterval   = 200 * time.Millisecond
+	rebalanceCatchUpTimeout = 10 * time.Minute
+	//writes are refused while a partition is frozen, so the final catch up must be quick
+	rebalanceSwitchTimeout = 30 * time.Second
+)
+
+var ErrRebalanceInProgress = errors.New("a rebalance is already running")
+var ErrUnknownNode = errors.New("node is not part of the partition table")
+var ErrNodeExists = errors.New("node is already part of the partition table")
+var ErrLastNode = errors.New("cannot remove the last node")
+var ErrCatchUpTimeout = errors.New("new owner did not catch up in time")
+
+type Rebalance struct {
+	State      string     `json:"state"`
+	Nodes      []string   `json:"nodes"`
+	Moves      []Move     `json:"moves"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+func PartitionPath(partition int) string {
+	return fmt.Sprintf("%s/%d", TablePath, partition)
+}
+
+type partitionOps interface {
+	status(partition int) (PartitionStatus, error)
+	copy(partition int, from string) error
+	freeze(partition int, frozen bool) error
+	promote(partition int) error
+	drop(partition int) error
+	installTable(table Table) error
+	split(partition int) error
+	prepareSplit(partition int, created int) (string, error)
+	finishSplit(partition int, at string) error
+	scan(partition int, start string, end string, limit int) ([]kvstore.KeyValue, error)
+}
+
+type localOps struct {
+	node *Node
+}
+
+func (ops localOps) status(partition int) (PartitionStatus, error) {
+	return ops.node.Status(partition)
+}
+
+func (ops localOps) copy(partition int, from string) error {
+	return ops.node.Copy(partition, from)
+}
+
+func (ops localOps) freeze(partition int, frozen bool) error {
+	return ops.node.Freeze(partition, frozen)
+}
+
+func (ops localOps) promote(partition int) error {
+	return ops.node.Promote(partition)
+}
+
+func (ops localOps) drop(partition int) error {
+	return ops.node.Drop(partition)
+}
+
+func (ops localOps) installTable(table Table) error {
+	err := ops.node.InstallTable(table)
+	if errors.Is(err, ErrStaleTable) {
+		return nil
+	}
+	return err
+}
+
+func (ops localOps) split(partition int) error {
+	return ops.node.runSplit(partition)
+}
+
+func (ops localOps) prepareSplit(partition int, created int) (string, error) {
+	return ops.node.PrepareSplit(partition, created)
+}
+
+func (ops localOps) finishSplit(partition int, at string) error {
+	return ops.node.FinishSplit(partition, at)
+}
+
+func (ops localOps) scan(partition int, start string, end string, limit int) ([]kvstore.KeyValue, error) {
+	return ops.node.ScanPartition(partition, start, end, limit)
+}
+
+type remoteOps struct {
+	node *Node
+	peer cluster.Peer
+}
+
+func (ops remoteOps) call(method string, path string, request any, response any) error {
+	var body bytes.Buffer
+	if request != nil {
+		err := json.NewEncoder(&body).Encode(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	httpRequest, err := http.NewRequest(method, ops.peer.Address+path, &body)
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := ops.node.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", ops.peer.Id, httpResponse.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+func (ops remoteOps) status(partition int) (PartitionStatus, error) {
+	var status PartitionStatus
+	err := ops.call(http.MethodGet, PartitionPath(partition), nil, &status)
+	return status, err
+}
+
+func (ops remoteOps) copy(partition int, from string) error {
+	return ops.call(http.MethodPost, PartitionPath(partition)+"/copy", map[string]string{"from": from}, nil)
+}
+
+func (ops remoteOps) freeze(partition int, frozen bool) error {
+	return ops.call(http.MethodPost, PartitionPath(partition)+"/freeze", map[string]bool{"frozen": frozen}, nil)
+}
+
+func (ops remoteOps) promote(partition int) error {
+	return ops.call(http.MethodPost, PartitionPath(partition)+"/promote", nil, nil)
+}
+
+func (ops remoteOps) drop(partition int) error {
+	return ops.call(http.MethodDelete, PartitionPath(partition), nil, nil)
+}
+
+func (ops remoteOps) installTable(table Table) error {
+	return ops.call(http.MethodPost, TablePath, table, nil)
+}
+
+func (ops remoteOps) split(partition int) error {
+	return ops.call(http.MethodPost, PartitionPath(partition)+"/split", nil, nil)
+}
+
+func (ops remoteOps) prepareSplit(partition int, created int) (string, error) {
+	var response struct {
+		At string `json:"at"`
+	}
+	err := ops.call(http.MethodPost, PartitionPath(partition)+"/split/prepare", map[string]int{"partition": created}, &response)
+	return response.At, err
+}
+
+func (ops remoteOps) finishSplit(partition int, at string) error {
+	return ops.call(http.MethodPost, PartitionPath(partition)+"/split/finish", map[string]string{"at": at}, nil)
+}
+
+func (ops remoteOps) scan(partition int, start string, end string, limit int) ([]kvstore.KeyValue, error) {
+	query := url.Values{}
+	query.Set("start", start)
+	query.Set("end", end)
+	query.Set("limit", strconv.Itoa(limit))
+
+	var items []kvstore.KeyValue
+	err := ops.call(http.MethodGet, PartitionPath(partition)+"/scan?"+query.Encode(), nil, &items)
+	return items, err
+}
+
+func (node *Node) at(table *Table, id string) (partitionOps, cluster.Peer, error) {
+	peer, exists := table.node(id)
+	if id == node.selfId {
+		return localOps{node: node}, peer, nil
+	}
+	if !exists || peer.Address == "" {
+		return nil, peer, fmt.Errorf("%w: %s", ErrUnknownOwner, id)
+	}
+	return remoteOps{node: node, peer: peer}, peer, nil
+}
+
+func (node *Node) RebalanceStatus() *Rebalance {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.rebalance == nil {
+		return nil
+	}
+	status := *node.rebalance
+	status.Moves = append([]Move{}, node.rebalance.Moves...)
+	return &status
+}
+
+func (node *Node) AddNode(peer cluster.Peer) error {
+	return node.startRebalance(func(nodes []cluster.Peer) ([]cluster.Peer, error) {
+		for _, existing := range nodes {
+			if existing.Id == peer.Id {
+				return nil, ErrNodeExists
+			}
+		}
+		return append(nodes, peer), nil
+	})
+}
+
+func (node *Node) RemoveNode(id string) error {
+	return node.startRebalance(func(nodes []cluster.Peer) ([]cluster.Peer, error) {
+		remaining := []cluster.Peer{}
+		for _, existing := range nodes {
+			if existing.Id != id {
+				remaining = append(remaining, existing)
+			}
+		}
+
+		if len(remaining) == len(nodes) {
+			return nil, ErrUnknownNode
+		}
+		if len(remaining) == 0 {
+			return nil, ErrLastNode
+		}
+		return remaining, nil
+	})
+}
+
+func (node *Node) startRebalance(change func(nodes []cluster.Peer) ([]cluster.Peer, error)) error {
+	node.mutex.Lock()
+	if node.splitting || (node.rebalance != nil && node.rebalance.State == RebalanceRunning) {
+		node.mutex.Unlock()
+		return ErrRebalanceInProgress
+	}
+
+	nodes, err := change(append([]cluster.Peer{}, node.table.Nodes...))
+	if err != nil {
+		node.mutex.Unlock()
+		return err
+	}
+
+	//every node has to know where the others are before any partition moves, leaving ones included
+	table := node.table.clone()
+	for _, peer := range nodes {
+		if _, exists := table.node(peer.Id); !exists {
+			table.Nodes = append(table.Nodes, peer)
+		}
+	}
+	table.Version++
+
+	ids := []string{}
+	for _, peer := range nodes {
+		ids = append(ids, peer.Id)
+	}
+	sort.Strings(ids)
+
+	rebalance := &Rebalance{
+		State:     RebalanceRunning,
+		Nodes:     ids,
+		Moves:     Plan(table, ids),
+		StartedAt: time.Now(),
+	}
+	node.rebalance = rebalance
+	node.mutex.Unlock()
+
+	err = node.publish(table)
+	if err != nil {
+		node.finishRebalance(err)
+		return err
+	}
+
+	go node.runRebalance(nodes)
+	return nil
+}
+
+func (node *Node) publish(table *Table, required ...string) error {
+	//nodes a change does not touch may miss it, they pick it up from the owners they forward to
+	required = append(required, node.selfId)
+	var firstErr error
+	for _, peer := range table.Nodes {
+		ops, _, err := node.at(table, peer.Id)
+		if err == nil {
+			err = ops.installTable(*table)
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Printf("partition table %d not installed on %s: %v", table.Version, peer.Id, err)
+		for _, id := range required {
+			if id == peer.Id && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (node *Node) setMove(i int, state string, err error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.rebalance.Moves[i].State = state
+	if err != nil {
+		node.rebalance.Moves[i].Error = err.Error()
+	}
+}
+
+func (node *Node) finishRebalance(err error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	now := time.Now()
+	node.rebalance.FinishedAt = &now
+	node.rebalance.State = RebalanceDone
+	if err != nil {
+		node.rebalance.State = RebalanceFailed
+		node.rebalance.Error = err.Error()
+	}
+}
+
+func (node *Node) runRebalance(nodes []cluster.Peer) {
+	node.mutex.Lock()
+	moves := append([]Move{}, node.rebalance.Moves...)
+	node.mutex.Unlock()
+
+	for i, move := range moves {
+		err := node.move(i, move)
+		if err != nil {
+			log.Printf("partition %d: moving from %s to %s failed: %v", move.Partition, move.From, move.To, err)
+			node.setMove(i, MoveFailed, err)
+			node.finishRebalance(err)
+			return
+		}
+		node.setMove(i, MoveDone, nil)
+	}
+
+	//nodes that left own nothing any more and drop out of the table
+	node.mutex.Lock()
+	table := node.table.clone()
+	table.Nodes = nodes
+	table.Version++
+	node.mutex.Unlock()
+
+	node.finishRebalance(node.publish(table))
+}
+
+func (node *Node) waitFor(source partitionOps, target partitionOps, partition int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		sourceStatus, err := source.status(partition)
+		if err != nil {
+			return err
+		}
+		targetStatus, err := target.status(partition)
+		if err != nil {
+			return err
+		}
+
+		if targetStatus.LastIndex >= sourceStatus.LastIndex {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrCatchUpTimeout
+		}
+		time.Sleep(rebalancePollInterval)
+	}
+}
+
+func (node *Node) move(i int, move Move) error {
+	node.mutex.Lock()
+	table := node.table.clone()
+	node.mutex.Unlock()
+
+	source, sourcePeer, err := node.at(table, move.From)
+	if err != nil {
+		return err
+	}
+	target, _, err := node.at(table, move.To)
+	if err != nil {
+		return err
+	}
+	if sourcePeer.Address == "" {
+		return fmt.Errorf("address of %s is unknown, start it with -advertise", move.From)
+	}
+
+	//the new owner replays the partition's segments and then tails its wal
+	node.setMove(i, MoveCopying, nil)
+	err = target.copy(move.Partition, sourcePeer.Address)
+	if err != nil {
+		return err
+	}
+	err = node.waitFor(source, target, move.Partition, rebalanceCatchUpTimeout)
+	if err != nil {
+		target.drop(move.Partition)
+		return err
+	}
+
+	//writes stop for the moment it takes the new owner to get the last few entries
+	node.setMove(i, MoveSwitch, nil)
+	err = source.freeze(move.Partition, true)
+	if err != nil {
+		return err
+	}
+	err = node.waitFor(source, target, move.Partition, rebalanceSwitchTimeout)
+	if err == nil {
+		err = target.promote(move.Partition)
+	}
+	if err != nil {
+		source.freeze(move.Partition, false)
+		target.drop(move.Partition)
+		return err
+	}
+
+	node.mutex.Lock()
+	table = node.table.clone()
+	table.Owners[move.Partition] = move.To
+	table.Version++
+	node.mutex.Unlock()
+
+	//a source that missed the new table stays frozen rather than take writes it no longer owns
+	err = node.publish(table, move.From, move.To)
+	if err != nil {
+		return err
+	}
+	source.freeze(move.Partition, false)
+
+	node.setMove(i, MoveDropping, nil)
+	return source.drop(move.Partition)
+}
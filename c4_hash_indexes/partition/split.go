@@ -0,0 +1,336 @@
+package partition
+
+import (
+	"errors"
+	"fmt"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"log"
+	"time"
+)
+
+const (
+	splitCheckInterval = 10 * time.Second
+	splitBatchSize     = 1000
+)
+
+var ErrNotRanged = errors.New("partitions are not key ranges, start the nodes with -ranges")
+var ErrRangeTooSmall = errors.New("range has too few keys to split")
+
+func (node *Node) watchLoad() {
+	ticker := time.NewTicker(splitCheckInterval)
+	for range ticker.C {
+		node.checkLoad()
+	}
+}
+
+func (node *Node) checkLoad() {
+	node.mutex.Lock()
+	hot := []int{}
+	for _, partition := range node.table.OwnedBy(node.selfId) {
+		store, exists := node.stores[partition]
+		if !exists || node.frozen[partition] {
+			continue
+		}
+
+		//a range that was just split still holds its upper half until FinishSplit has deleted it
+		r, _ := node.table.Range(partition)
+		keys := store.KeyCount(r.Start, r.End)
+		qps := float64(node.hits[partition]) / splitCheckInterval.Seconds()
+		if (node.config.SplitKeys > 0 && keys > node.config.SplitKeys) || (node.config.SplitQps > 0 && qps > node.config.SplitQps) {
+			log.Printf("partition %d: %d keys at %.1f requests a second, asking for a split", partition, keys, qps)
+			hot = append(hot, partition)
+		}
+	}
+	node.hits = make(map[int]uint64)
+	node.mutex.Unlock()
+
+	for _, partition := range hot {
+		err := node.Split(partition)
+		if err != nil && !errors.Is(err, ErrRebalanceInProgress) {
+			log.Printf("partition %d: split failed: %v", partition, err)
+		}
+	}
+}
+
+func (node *Node) Split(partition int) error {
+	node.mutex.Lock()
+	table := node.table.clone()
+	node.mutex.Unlock()
+
+	if !table.IsRanged() {
+		return ErrNotRanged
+	}
+	if directory := table.directory(); directory != node.selfId {
+		ops, _, err := node.at(table, directory)
+		if err != nil {
+			return err
+		}
+		return ops.split(partition)
+	}
+	return node.runSplit(partition)
+}
+
+func (node *Node) runSplit(partition int) (err error) {
+	node.mutex.Lock()
+	if node.splitting || (node.rebalance != nil && node.rebalance.State == RebalanceRunning) {
+		node.mutex.Unlock()
+		return ErrRebalanceInProgress
+	}
+	err = node.checkPartition(partition)
+	if err != nil {
+		node.mutex.Unlock()
+		return err
+	}
+	node.splitting = true
+	table := node.table.clone()
+	node.mutex.Unlock()
+
+	defer func() {
+		node.mutex.Lock()
+		node.splitting = false
+		node.mutex.Unlock()
+
+		if err == nil {
+			go node.spread()
+		}
+	}()
+
+	owner := table.Owners[partition]
+	ops, _, err := node.at(table, owner)
+	if err != nil {
+		return err
+	}
+
+	//the owner copies the upper half while writes go on, and leaves the partition frozen once it has caught up
+	created := table.Count
+	at, err := ops.prepareSplit(partition, created)
+	if err != nil {
+		ops.freeze(partition, false)
+		return err
+	}
+
+	node.mutex.Lock()
+	table = node.table.clone()
+	if table.Count != created || table.Owners[partition] != owner {
+		node.mutex.Unlock()
+		ops.freeze(partition, false)
+		return fmt.Errorf("partition table changed while partition %d was being split", partition)
+	}
+	table.split(partition, at)
+	table.Version++
+	node.mutex.Unlock()
+
+	//an owner that missed the new table stays frozen rather than take writes for keys it handed over
+	err = node.publish(table, owner)
+	if err != nil {
+		return err
+	}
+	err = ops.finishSplit(partition, at)
+	if err != nil {
+		return err
+	}
+	log.Printf("partition %d: split at %q, the keys from there on are partition %d", partition, at, created)
+	return nil
+}
+
+func (node *Node) spread() {
+	node.mutex.Lock()
+	moves := Plan(node.table, sortedIds(node.table.Nodes))
+	node.mutex.Unlock()
+
+	//both halves of a split start on the same node, a rebalance evens the ranges out
+	if len(moves) == 0 {
+		return
+	}
+	err := node.startRebalance(func(nodes []cluster.Peer) ([]cluster.Peer, error) {
+		return nodes, nil
+	})
+	if err != nil {
+		log.Printf("partitions: spreading ranges after a split: %v", err)
+	}
+}
+
+func (node *Node) PrepareSplit(partition int, created int) (string, error) {
+	node.mutex.Lock()
+	r, exists := node.table.Range(partition)
+	if !exists {
+		node.mutex.Unlock()
+		return "", ErrUnknownPartition
+	}
+	if node.table.Owners[partition] != node.selfId {
+		node.mutex.Unlock()
+		return "", ErrNotOwner
+	}
+	if created != node.table.Count {
+		node.mutex.Unlock()
+		return "", fmt.Errorf("partition %d already exists", created)
+	}
+
+	//whatever an earlier, abandoned split left behind is thrown away
+	node.dropLocked(created)
+	source := node.storeLocked(partition)
+	if source.KeyCount(r.Start, r.End) < 2 {
+		node.mutex.Unlock()
+		return "", ErrRangeTooSmall
+	}
+	//the new store is only made once the split is going ahead
+	target := node.storeLocked(created)
+	node.mutex.Unlock()
+
+	//the copy is taken while writes go on, everything written from here on is replayed over it
+	from := source.LastIndex() + 1
+	at, _ := source.MedianKey(r.Start, r.End)
+	ops := []kvstore.BatchOp{}
+	for _, item := range source.Scan(at, r.End, 0) {
+		ops = append(ops, kvstore.BatchOp{Key: item.Key, Value: item.Value})
+	}
+	err := writeInBatches(target, ops)
+	if err != nil {
+		return "", err
+	}
+
+	//writes only stop for the catch-up, like the last step of a move
+	err = node.Freeze(partition, true)
+	if err != nil {
+		return "", err
+	}
+	return at, catchUpSplit(source, target, from, at, r.End)
+}
+
+func catchUpSplit(source *kvstore.KvStore, target *kvstore.KvStore, from uint64, at string, end string) error {
+	head := source.LastIndex()
+	if from > head {
+		return nil
+	}
+
+	sub := source.Subscribe(from)
+	defer sub.Close()
+
+	//the partition is frozen meanwhile, so this gets no longer than the switch of a move
+	timeout := time.NewTimer(rebalanceSwitchTimeout)
+	defer timeout.Stop()
+
+	ops := []kvstore.BatchOp{}
+	for {
+		select {
+		case change, open := <-sub.Changes():
+			if !open {
+				return sub.Err()
+			}
+			if change.Key != "" && change.Key >= at && (end == "" || change.Key < end) {
+				ops = append(ops, kvstore.BatchOp{Key: change.Key, Value: valueOf(change.Value), Delete: change.Value == nil})
+			}
+			if change.Index >= head {
+				return writeInBatches(target, ops)
+			}
+		case <-timeout.C:
+			return ErrCatchUpTimeout
+		}
+	}
+}
+
+func valueOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func writeInBatches(store *kvstore.KvStore, ops []kvstore.BatchOp) error {
+	//the wal metadata is saved once a batch, not once a key
+	for len(ops) > 0 {
+		size := min(len(ops), splitBatchSize)
+		_, err := store.WriteBatch("", ops[:size])
+		if err != nil {
+			return err
+		}
+		ops = ops[size:]
+	}
+	return nil
+}
+
+func (node *Node) FinishSplit(partition int, at string) error {
+	node.mutex.Lock()
+	r, exists := node.table.Range(partition)
+	if !exists || r.End != at {
+		node.mutex.Unlock()
+		return fmt.Errorf("partition %d does not end at %q in partition table %d", partition, at, node.table.Version)
+	}
+	store := node.storeLocked(partition)
+	node.mutex.Unlock()
+
+	//writes are routed by the new table, so none of them can land in the upper half any more
+	err := node.Freeze(partition, false)
+	if err != nil {
+		return err
+	}
+
+	ops := []kvstore.BatchOp{}
+	for _, item := range store.Scan(at, "", 0) {
+		ops = append(ops, kvstore.BatchOp{Key: item.Key, Delete: true})
+	}
+	return writeInBatches(store, ops)
+}
+
+func (node *Node) Scan(start string, end string, limit int) ([]kvstore.KeyValue, error) {
+	node.mutex.Lock()
+	table := node.table.clone()
+	node.mutex.Unlock()
+
+	if !table.IsRanged() {
+		return nil, ErrNotRanged
+	}
+
+	//ranges are visited in key order, a prefix that falls inside one range is answered by one node
+	items := []kvstore.KeyValue{}
+	for _, r := range table.RangesBetween(start, end) {
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - len(items)
+			if remaining <= 0 {
+				break
+			}
+		}
+
+		owner := table.Owners[r.Partition]
+		if owner == "" {
+			return nil, ErrNoOwner
+		}
+		ops, _, err := node.at(table, owner)
+		if err != nil {
+			return nil, err
+		}
+		found, err := ops.scan(r.Partition, start, end, remaining)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, found...)
+	}
+	return items, nil
+}
+
+func (node *Node) ScanPartition(partition int, start string, end string, limit int) ([]kvstore.KeyValue, error) {
+	node.mutex.Lock()
+	r, exists := node.table.Range(partition)
+	if !exists {
+		node.mutex.Unlock()
+		return nil, ErrUnknownPartition
+	}
+	if node.table.Owners[partition] != node.selfId {
+		node.mutex.Unlock()
+		return nil, ErrNotOwner
+	}
+	node.hits[partition]++
+	store := node.storeLocked(partition)
+	node.mutex.Unlock()
+
+	//a partition that was just split may still hold keys of its upper half
+	if start < r.Start {
+		start = r.Start
+	}
+	if r.End != "" && (end == "" || end > r.End) {
+		end = r.End
+	}
+	return store.Scan(start, end, limit), nil
+}
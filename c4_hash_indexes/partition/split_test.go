@@ -0,0 +1,34 @@
+package partition
+
+import (
+	"errors"
+	"keyvault/cluster"
+	"os"
+	"testing"
+)
+
+func TestRefusedSplitLeavesNoStore(t *testing.T) {
+	node, err := NewNode(cluster.Peer{Id: "n1", Address: "http://localhost:1"}, t.TempDir(), nil, Config{Ranges: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Store(0).Put("a", "1")
+
+	//a single key cannot be split, and nothing is made for the range that would have been
+	_, err = node.PrepareSplit(0, 1)
+	if !errors.Is(err, ErrRangeTooSmall) {
+		t.Fatalf("splitting a single key gave %v", err)
+	}
+	if _, err := os.Stat(node.partitionDir(1)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("a refused split left a store behind")
+	}
+
+	node.Store(0).Put("b", "1")
+	at, err := node.PrepareSplit(0, 1)
+	if err != nil || at != "b" {
+		t.Fatalf("splitting two keys gave %q %v", at, err)
+	}
+	if value := node.Store(1).Get("b"); value == nil || *value != "1" {
+		t.Fatal("the upper half was not copied to the new range")
+	}
+}
@@ -0,0 +1,249 @@
+package partition
+
+import (
+	"errors"
+	"hash/fnv"
+	"keyvault/cluster"
+	"sort"
+)
+
+var ErrNoNodes = errors.New("a partition table needs at least one node")
+
+type Table struct {
+	Count   int            `json:"count"`
+	Owners  []string       `json:"owners"`
+	Nodes   []cluster.Peer `json:"nodes"`
+	Version uint64         `json:"version"`
+	Ranges  []Range        `json:"ranges,omitempty"`
+}
+
+type Range struct {
+	Partition int    `json:"partition"`
+	Start     string `json:"start"`
+	End       string `json:"end,omitempty"`
+}
+
+func (r Range) contains(key string) bool {
+	return key >= r.Start && (r.End == "" || key < r.End)
+}
+
+type Move struct {
+	Partition int    `json:"partition"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
+func hashOf(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	return hash.Sum64()
+}
+
+func PartitionOf(key string, count int) int {
+	return int(hashOf(key) % uint64(count))
+}
+
+func NewTable(count int, nodes []cluster.Peer) (*Table, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	//every node builds the same table, so the ids are sorted before partitions are dealt out
+	ids := sortedIds(nodes)
+
+	table := &Table{Count: count, Owners: make([]string, count), Nodes: nodes, Version: 1}
+	for partition := 0; partition < count; partition++ {
+		table.Owners[partition] = ids[partition%len(ids)]
+	}
+
+	return table, nil
+}
+
+func NewRangeTable(nodes []cluster.Peer) (*Table, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	//the whole keyspace starts out as one range, splits carve it up as keys arrive
+	table := &Table{Count: 1, Nodes: nodes, Version: 1, Ranges: []Range{{Partition: 0}}}
+	table.Owners = []string{sortedIds(nodes)[0]}
+	return table, nil
+}
+
+func sortedIds(nodes []cluster.Peer) []string {
+	ids := []string{}
+	for _, node := range nodes {
+		ids = append(ids, node.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (table *Table) clone() *Table {
+	clone := &Table{
+		Count:   table.Count,
+		Owners:  append([]string{}, table.Owners...),
+		Nodes:   append([]cluster.Peer{}, table.Nodes...),
+		Version: table.Version,
+	}
+	if table.Ranges != nil {
+		clone.Ranges = append([]Range{}, table.Ranges...)
+	}
+	return clone
+}
+
+func (table *Table) IsRanged() bool {
+	return table.Ranges != nil
+}
+
+func (table *Table) Owner(key string) (int, string) {
+	partition := PartitionOf(key, table.Count)
+	if table.IsRanged() {
+		partition = table.rangeOf(key).Partition
+	}
+	return partition, table.Owners[partition]
+}
+
+func (table *Table) rangeOf(key string) Range {
+	//ranges are sorted by their start and the first one starts at the empty key
+	i := sort.Search(len(table.Ranges), func(i int) bool {
+		return table.Ranges[i].Start > key
+	})
+	return table.Ranges[i-1]
+}
+
+func (table *Table) Range(partition int) (Range, bool) {
+	for _, r := range table.Ranges {
+		if r.Partition == partition {
+			return r, true
+		}
+	}
+	return Range{}, false
+}
+
+func (table *Table) RangesBetween(start string, end string) []Range {
+	ranges := []Range{}
+	for _, r := range table.Ranges {
+		if end != "" && r.Start >= end {
+			break
+		}
+		if r.End != "" && r.End <= start {
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func (table *Table) split(partition int, at string) int {
+	//the upper half becomes a new partition that stays with the same owner until a rebalance moves it
+	created := table.Count
+	table.Count++
+	table.Owners = append(table.Owners, table.Owners[partition])
+
+	ranges := []Range{}
+	for _, r := range table.Ranges {
+		if r.Partition != partition {
+			ranges = append(ranges, r)
+			continue
+		}
+		ranges = append(ranges, Range{Partition: partition, Start: r.Start, End: at})
+		ranges = append(ranges, Range{Partition: created, Start: at, End: r.End})
+	}
+	table.Ranges = ranges
+	return created
+}
+
+func (table *Table) directory() string {
+	//splits all go through one node so two of them never hand out the same partition number
+	return sortedIds(table.Nodes)[0]
+}
+
+func (table *Table) OwnedBy(nodeId string) []int {
+	partitions := []int{}
+	for partition, owner := range table.Owners {
+		if owner == nodeId {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+func (table *Table) node(id string) (cluster.Peer, bool) {
+	for _, node := range table.Nodes {
+		if node.Id == id {
+			return node, true
+		}
+	}
+	return cluster.Peer{}, false
+}
+
+func Plan(table *Table, nodeIds []string) []Move {
+	if len(nodeIds) == 0 {
+		return []Move{}
+	}
+
+	ids := append([]string{}, nodeIds...)
+	sort.Strings(ids)
+
+	owned := make(map[string][]int)
+	for _, id := range ids {
+		owned[id] = []int{}
+	}
+
+	//partitions of nodes that are leaving have to go somewhere
+	homeless := []int{}
+	for partition, owner := range table.Owners {
+		if _, staying := owned[owner]; staying {
+			owned[owner] = append(owned[owner], partition)
+		} else {
+			homeless = append(homeless, partition)
+		}
+	}
+
+	least := func() string {
+		best := ids[0]
+		for _, id := range ids {
+			if len(owned[id]) < len(owned[best]) {
+				best = id
+			}
+		}
+		return best
+	}
+	most := func() string {
+		best := ids[0]
+		for _, id := range ids {
+			if len(owned[id]) > len(owned[best]) {
+				best = id
+			}
+		}
+		return best
+	}
+
+	moves := []Move{}
+	for _, partition := range homeless {
+		to := least()
+		owned[to] = append(owned[to], partition)
+		moves = append(moves, Move{Partition: partition, From: table.Owners[partition], To: to})
+	}
+
+	//then only as many partitions move as it takes to even out the load
+	for {
+		from, to := most(), least()
+		if len(owned[from])-len(owned[to]) <= 1 {
+			break
+		}
+
+		partition := owned[from][len(owned[from])-1]
+		owned[from] = owned[from][:len(owned[from])-1]
+		owned[to] = append(owned[to], partition)
+		moves = append(moves, Move{Partition: partition, From: from, To: to})
+	}
+
+	for i := range moves {
+		moves[i].State = MovePending
+	}
+	return moves
+}
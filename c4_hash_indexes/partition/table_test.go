@@ -0,0 +1,86 @@
+package partition
+
+import (
+	"errors"
+	"keyvault/cluster"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+var tableNodes = []cluster.Peer{{Id: "n2"}, {Id: "n1"}, {Id: "n3"}}
+
+func TestHashTableOwners(t *testing.T) {
+	table, err := NewTable(8, tableNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//every node deals the partitions out the same way, whatever order it was given the nodes in
+	reversed, _ := NewTable(8, []cluster.Peer{tableNodes[2], tableNodes[1], tableNodes[0]})
+	if !slices.Equal(table.Owners, reversed.Owners) {
+		t.Fatalf("the owners differ by node order, %v and %v", table.Owners, reversed.Owners)
+	}
+	for _, id := range []string{"n1", "n2", "n3"} {
+		if owned := len(table.OwnedBy(id)); owned < 2 || owned > 3 {
+			t.Fatalf("%s owns %d of 8 partitions", id, owned)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		partition, owner := table.Owner(key)
+		if partition != PartitionOf(key, 8) || owner != table.Owners[partition] {
+			t.Fatalf("%s is routed to partition %d on %s", key, partition, owner)
+		}
+	}
+
+	if _, err := NewTable(8, nil); !errors.Is(err, ErrNoNodes) {
+		t.Fatalf("a table without nodes gave %v", err)
+	}
+}
+
+func TestRangeTableOwners(t *testing.T) {
+	table, err := NewRangeTable(tableNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partition, owner := table.Owner("anything"); partition != 0 || owner != "n1" {
+		t.Fatalf("a single range routed to %d on %s", partition, owner)
+	}
+
+	//a split hands the upper half to a new partition on the same owner
+	upper := table.split(0, "m")
+	table.Owners[upper] = "n2"
+	for key, expected := range map[string]int{"": 0, "a": 0, "lzz": 0, "m": upper, "z": upper} {
+		if partition, _ := table.Owner(key); partition != expected {
+			t.Fatalf("%q is in partition %d, expected %d", key, partition, expected)
+		}
+	}
+	if _, owner := table.Owner("z"); owner != "n2" {
+		t.Fatalf("the upper range is owned by %s", owner)
+	}
+
+	between := func(start string, end string) []int {
+		partitions := []int{}
+		for _, r := range table.RangesBetween(start, end) {
+			partitions = append(partitions, r.Partition)
+		}
+		return partitions
+	}
+	for _, test := range []struct {
+		start    string
+		end      string
+		expected []int
+	}{
+		{"", "", []int{0, upper}},
+		{"a", "c", []int{0}},
+		{"a", "m", []int{0}},
+		{"a", "n", []int{0, upper}},
+		{"m", "", []int{upper}},
+	} {
+		if got := between(test.start, test.end); !slices.Equal(got, test.expected) {
+			t.Fatalf("ranges between %q and %q are %v, expected %v", test.start, test.end, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"keyvault/cluster"
+	"keyvault/kvstore"
+	"keyvault/partition"
+	"net/http"
+	"strconv"
+)
+
+const partitionHeader = "X-Kv-Partition"
+const partitionTableHeader = "X-Kv-Partition-Table"
+
+var partitions *partition.Node
+
+func handlePartitionError(w http.ResponseWriter, e error) {
+	if errors.Is(e, partition.ErrPartitionFrozen) || errors.Is(e, partition.ErrNotOwner) || errors.Is(e, partition.ErrNoOwner) {
+		retryLater(w, e.Error())
+		return
+	}
+	if errors.Is(e, partition.ErrUnknownPartition) || errors.Is(e, partition.ErrUnknownNode) || errors.Is(e, partition.ErrLastNode) {
+		http.Error(w, e.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(e, partition.ErrNotRanged) || errors.Is(e, partition.ErrRangeTooSmall) {
+		handleHttpError(w, e)
+		return
+	}
+	if errors.Is(e, partition.ErrStaleTable) || errors.Is(e, partition.ErrRebalanceInProgress) || errors.Is(e, partition.ErrNodeExists) {
+		http.Error(w, e.Error(), http.StatusConflict)
+		return
+	}
+	handleWriteError(w, e)
+}
+
+func partitionedHandler(w http.ResponseWriter, req *http.Request) {
+	method := req.Method
+
+	var key string
+	var body []byte
+	if method == http.MethodPost {
+		body, _ = io.ReadAll(req.Body)
+
+		var request PutRequest
+		err := json.Unmarshal(body, &request)
+		if err != nil || !request.isValid() {
+			handleHttpError(w, err)
+			return
+		}
+		key = request.Key
+	} else if method == http.MethodGet || method == http.MethodDelete {
+		key = req.URL.Query().Get("key")
+		if key == "" {
+			handleHttpError(w, nil)
+			return
+		}
+	} else {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Set(partitionTableHeader, strconv.FormatUint(partitions.Table().Version, 10))
+	route, err := partitions.Route(key)
+	w.Header().Set(partitionHeader, strconv.Itoa(route.Partition))
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+
+	//a request that was already routed once is never routed again, the nodes disagree about the owner
+	if !route.Local {
+		if req.Header.Get(forwardedHeader) != "" {
+			retryLater(w, "partition "+strconv.Itoa(route.Partition)+" is moving, retry shortly")
+			return
+		}
+
+		err := relay(w, req, route.Owner.Address, bytes.NewReader(body))
+		if err != nil {
+			retryLater(w, "partition owner "+route.Owner.Id+" is unreachable, retry shortly")
+			return
+		}
+
+		if version, err := strconv.ParseUint(w.Header().Get(partitionTableHeader), 10, 64); err == nil {
+			partitions.Observe(route.Owner, version)
+		}
+		return
+	}
+
+	clientId, sequence, err := requestIdentity(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	if method == http.MethodGet {
+		value, version, err := partitions.Store(route.Partition).GetConsistent(key, kvstore.ConsistencyStale)
+		if err != nil {
+			handleWriteError(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"key": key,
+			"value": func() string {
+				if value == nil {
+					return ""
+				}
+				return *value
+			}(),
+			"version": version,
+		})
+		return
+	}
+
+	var index uint64
+	err = partitions.Write(route.Partition, []string{key}, func(store *kvstore.KvStore) error {
+		var err error
+		if method == http.MethodPost {
+			var request PutRequest
+			json.Unmarshal(body, &request)
+			index, err = store.PutIdempotent(clientId, sequence, request.Key, request.Value)
+		} else {
+			index, err = store.DeleteIdempotent(clientId, sequence, key)
+		}
+		return err
+	})
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+	writeIndexToken(w, index)
+}
+
+func partitionTableHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		body, _ := io.ReadAll(req.Body)
+
+		var table partition.Table
+		err := json.Unmarshal(body, &table)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		err = partitions.InstallTable(table)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+	} else if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(partitions.Table())
+}
+
+func partitionFromPath(w http.ResponseWriter, req *http.Request) (int, bool) {
+	value, err := strconv.Atoi(req.PathValue("partition"))
+	if err != nil {
+		handleHttpError(w, err)
+		return 0, false
+	}
+	return value, true
+}
+
+func partitionStatusHandler(w http.ResponseWriter, req *http.Request) {
+	id, ok := partitionFromPath(w, req)
+	if !ok {
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		err := partitions.Drop(id)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+	} else if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	status, err := partitions.Status(id)
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func partitionChangesHandler(w http.ResponseWriter, req *http.Request) {
+	id, ok := partitionFromPath(w, req)
+	if !ok {
+		return
+	}
+	//only the owner streams a partition, a copy being filled would hand out a partial log
+	status, err := partitions.Status(id)
+	if err == nil && !status.Owned {
+		err = partition.ErrNotOwner
+	}
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+
+	streamChanges(w, req, partitions.Store(id))
+}
+
+func partitionActionHandler(action func(id int, body []byte) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+			return
+		}
+
+		id, ok := partitionFromPath(w, req)
+		if !ok {
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		err := action(id, body)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+
+		status, _ := partitions.Status(id)
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+func copyPartition(id int, body []byte) error {
+	var request struct {
+		From string `json:"from"`
+	}
+	err := json.Unmarshal(body, &request)
+	if err != nil || request.From == "" {
+		return errors.New("a copy needs the address to copy from")
+	}
+	return partitions.Copy(id, request.From)
+}
+
+func freezePartition(id int, body []byte) error {
+	var request struct {
+		Frozen bool `json:"frozen"`
+	}
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return err
+	}
+	return partitions.Freeze(id, request.Frozen)
+}
+
+func promotePartition(id int, body []byte) error {
+	return partitions.Promote(id)
+}
+
+func splitPartition(id int, body []byte) error {
+	return partitions.Split(id)
+}
+
+func finishSplit(id int, body []byte) error {
+	var request struct {
+		At string `json:"at"`
+	}
+	err := json.Unmarshal(body, &request)
+	if err != nil || request.At == "" {
+		return errors.New("finishing a split needs the key it was split at")
+	}
+	return partitions.FinishSplit(id, request.At)
+}
+
+func prepareSplitHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	id, ok := partitionFromPath(w, req)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Partition int `json:"partition"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	at, err := partitions.PrepareSplit(id, request.Partition)
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"at": at})
+}
+
+func partitionScanHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	id, ok := partitionFromPath(w, req)
+	if !ok {
+		return
+	}
+	start, end, limit, err := scanBounds(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	items, err := partitions.ScanPartition(id, start, end, limit)
+	if err != nil {
+		handlePartitionError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func partitionedScanHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	start, end, limit, err := scanBounds(req)
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	//the range directory says which nodes hold the keys, each of them is asked in key order
+	w.Header().Set(partitionTableHeader, strconv.FormatUint(partitions.Table().Version, 10))
+	items, err := partitions.Scan(start, end, limit)
+	if errors.Is(err, partition.ErrNotRanged) {
+		handlePartitionError(w, err)
+		return
+	}
+	if err != nil {
+		retryLater(w, "ranges are moving or their owners are unreachable, retry shortly: "+err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func partitionNodesHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		body, _ := io.ReadAll(req.Body)
+
+		var request cluster.Peer
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		peer, err := cluster.NewPeer(request.Id, request.Address)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		err = partitions.AddNode(peer)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+	} else if req.Method == http.MethodDelete {
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			handleHttpError(w, nil)
+			return
+		}
+
+		err := partitions.RemoveNode(id)
+		if err != nil {
+			handlePartitionError(w, err)
+			return
+		}
+	} else {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	//the moves run in the background, their progress is at /partitions/rebalance
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(partitions.RebalanceStatus())
+}
+
+func rebalanceHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	status := partitions.RebalanceStatus()
+	if status == nil {
+		http.Error(w, "no rebalance has run on this node", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
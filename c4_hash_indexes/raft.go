@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+func raftHandler(handle func(body []byte) ([]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		response, err := handle(body)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func raftStatusHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	status := store.RaftStatus()
+	if status == nil {
+		http.Error(w, "raft is not enabled on this node", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
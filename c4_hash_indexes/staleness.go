@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"keyvault/kvstore"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const indexHeader = "X-Kv-Index"
+const minIndexTimeout = 5 * time.Second
+
+func writeIndexToken(w http.ResponseWriter, index uint64) {
+	//clients pass the index back as min_index to read their own write from any node
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]uint64{
+		"index": index,
+	})
+}
+
+func followerReadReady(w http.ResponseWriter, req *http.Request) bool {
+	query := req.URL.Query()
+
+	if value := query.Get("min_index"); value != "" {
+		minIndex, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			handleHttpError(w, err)
+			return false
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), minIndexTimeout)
+		defer cancel()
+
+		err = store.WaitForIndex(ctx, minIndex)
+		if err != nil {
+			retryLater(w, err.Error())
+			return false
+		}
+	}
+
+	if value := query.Get("min_timestamp"); value != "" {
+		minTimestamp, err := kvstore.ParseTimestamp(value)
+		if err != nil {
+			handleHttpError(w, err)
+			return false
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), minIndexTimeout)
+		defer cancel()
+
+		err = store.WaitForTimestamp(ctx, minTimestamp)
+		if errors.Is(err, kvstore.ErrClockOffset) {
+			handleHttpError(w, err)
+			return false
+		}
+		if err != nil {
+			retryLater(w, err.Error())
+			return false
+		}
+	}
+
+	if value := query.Get("max_lag"); value != "" {
+		maxLag, err := time.ParseDuration(value)
+		if err != nil {
+			handleHttpError(w, err)
+			return false
+		}
+
+		lag, err := store.ReplicationLag()
+		if err != nil {
+			retryLater(w, err.Error())
+			return false
+		}
+		if lag > maxLag {
+			retryLater(w, "this node is "+lag.Round(time.Millisecond).String()+" behind the leader, more than max_lag")
+			return false
+		}
+	}
+
+	return true
+}
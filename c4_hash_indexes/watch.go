@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultWatchTimeout = 30 * time.Second
+const maxWatchTimeout = 5 * time.Minute
+
+func watchHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), 405)
+		return
+	}
+
+	query := req.URL.Query()
+	key := query.Get("key")
+	prefix := query.Get("prefix")
+
+	var since *uint64
+	if value := query.Get("since"); value != "" {
+		index, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+		since = &index
+	}
+
+	timeout := defaultWatchTimeout
+	if value := query.Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			handleHttpError(w, err)
+			return
+		}
+		timeout = min(parsed, maxWatchTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	change, err := store.Watch(ctx, key, prefix, since)
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		handleHttpError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change)
+}